@@ -13,14 +13,25 @@ import (
 	"github.com/grafana/grafana/pkg/setting"
 )
 
+const (
+	// opentsdbDialTimeout and opentsdbTLSHandshakeTimeout apply to OpenTSDB
+	// datasources without dialTimeout and tlsHandshakeTimeout settings, so
+	// that dead nodes fail fast instead of using up the whole query timeout.
+	opentsdbDialTimeout         = 5 * time.Second
+	opentsdbTLSHandshakeTimeout = 5 * time.Second
+)
+
 type proxyTransportCache struct {
 	cache map[int64]cachedTransport
+	// insecure holds the transports of GetInsecureHttpClient.
+	insecure map[int64]cachedTransport
 	sync.Mutex
 }
 
 // dataSourceTransport implements http.RoundTripper (https://golang.org/pkg/net/http/#RoundTripper)
 type dataSourceTransport struct {
 	headers   map[string]string
+	dialer    *net.Dialer
 	transport *http.Transport
 }
 
@@ -40,7 +51,8 @@ type cachedTransport struct {
 }
 
 var ptc = proxyTransportCache{
-	cache: make(map[int64]cachedTransport),
+	cache:    make(map[int64]cachedTransport),
+	insecure: make(map[int64]cachedTransport),
 }
 
 func (ds *DataSource) GetHttpClient() (*http.Client, error) {
@@ -56,11 +68,39 @@ func (ds *DataSource) GetHttpClient() (*http.Client, error) {
 	}, nil
 }
 
+// GetInsecureHttpClient returns a client like GetHttpClient does that skips
+// the verification of the server's TLS certificate, whatever tlsSkipVerify
+// is set to. Its transport is cached apart from the one of GetHttpClient.
+func (ds *DataSource) GetInsecureHttpClient() (*http.Client, error) {
+	transport, err := ds.getHttpTransport(true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}, nil
+}
+
 func (ds *DataSource) GetHttpTransport() (*dataSourceTransport, error) {
+	return ds.getHttpTransport(false)
+}
+
+// getHttpTransport returns the cached transport of the datasource, building
+// it again when the datasource was updated since. Insecure transports skip
+// TLS certificate verification.
+func (ds *DataSource) getHttpTransport(insecure bool) (*dataSourceTransport, error) {
 	ptc.Lock()
 	defer ptc.Unlock()
 
-	if t, present := ptc.cache[ds.Id]; present && ds.Updated.Equal(t.updated) {
+	cache := ptc.cache
+	if insecure {
+		cache = ptc.insecure
+	}
+
+	if t, present := cache[ds.Id]; present && ds.Updated.Equal(t.updated) {
 		return t.dataSourceTransport, nil
 	}
 
@@ -69,18 +109,23 @@ func (ds *DataSource) GetHttpTransport() (*dataSourceTransport, error) {
 		return nil, err
 	}
 
+	if insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
 	tlsConfig.Renegotiation = tls.RenegotiateFreelyAsClient
 
 	// Create transport which adds all
 	customHeaders := ds.getCustomHeaders()
+	dialTimeout, tlsHandshakeTimeout := ds.getConnectTimeouts()
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
 	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
-		Proxy:           http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout:   time.Duration(setting.DataProxyTimeout) * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSClientConfig:       tlsConfig,
+		Proxy:                 http.ProxyFromEnvironment,
+		Dial:                  dialer.Dial,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
@@ -88,10 +133,11 @@ func (ds *DataSource) GetHttpTransport() (*dataSourceTransport, error) {
 
 	dsTransport := &dataSourceTransport{
 		headers:   customHeaders,
+		dialer:    dialer,
 		transport: transport,
 	}
 
-	ptc.cache[ds.Id] = cachedTransport{
+	cache[ds.Id] = cachedTransport{
 		dataSourceTransport: dsTransport,
 		updated:             ds.Updated,
 	}
@@ -134,6 +180,31 @@ func (ds *DataSource) GetTLSConfig() (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
+// getConnectTimeouts returns the dial and TLS handshake timeouts of the
+// datasource. Without dialTimeout and tlsHandshakeTimeout settings, OpenTSDB
+// datasources get 5 seconds each and all others the [dataproxy] timeout and
+// 10 seconds.
+func (ds *DataSource) getConnectTimeouts() (time.Duration, time.Duration) {
+	dialTimeout, tlsHandshakeTimeout := time.Duration(setting.DataProxyTimeout)*time.Second, 10*time.Second
+	if ds.Type == DS_OPENTSDB {
+		dialTimeout, tlsHandshakeTimeout = opentsdbDialTimeout, opentsdbTLSHandshakeTimeout
+	}
+	return ds.getTimeout("dialTimeout", dialTimeout), ds.getTimeout("tlsHandshakeTimeout", tlsHandshakeTimeout)
+}
+
+// getTimeout returns the timeout configured in seconds by the given jsonData
+// key, or fallback when it isn't set
+func (ds *DataSource) getTimeout(key string, fallback time.Duration) time.Duration {
+	if ds.JsonData == nil {
+		return fallback
+	}
+
+	if seconds := ds.JsonData.Get(key).MustInt(0); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
 // getCustomHeaders returns a map with all the to be set headers
 // The map key represents the HeaderName and the value represents this header's value
 func (ds *DataSource) getCustomHeaders() map[string]string {
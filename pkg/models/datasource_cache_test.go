@@ -173,6 +173,104 @@ func TestDataSourceProxyCache(t *testing.T) {
 		})
 	})
 
+	Convey("When getting an insecure HTTP client for a datasource", t, func() {
+		clearDSProxyCache()
+
+		json := simplejson.New()
+		json.Set("tlsHandshakeTimeout", 3)
+		json.Set("httpHeaderName1", "X-Tenant")
+		encryptedData, err := util.Encrypt([]byte("team-a"), setting.SecretKey)
+		So(err, ShouldBeNil)
+
+		ds := DataSource{
+			Id:             1,
+			Url:            "https://k8s:8001",
+			Type:           "Kubernetes",
+			JsonData:       json,
+			SecureJsonData: map[string][]byte{"httpHeaderValue1": encryptedData},
+		}
+
+		client, err := ds.GetInsecureHttpClient()
+		So(err, ShouldBeNil)
+		tr := client.Transport.(*dataSourceTransport)
+
+		Convey("Should skip TLS verification", func() {
+			So(tr.transport.TLSClientConfig.InsecureSkipVerify, ShouldBeTrue)
+		})
+
+		Convey("Should keep the other settings of the datasource", func() {
+			So(tr.transport.TLSHandshakeTimeout, ShouldEqual, 3*time.Second)
+			So(tr.headers, ShouldResemble, map[string]string{"X-Tenant": "team-a"})
+		})
+
+		Convey("Should be cached apart from the verifying transport", func() {
+			verifying, err := ds.GetHttpTransport()
+			So(err, ShouldBeNil)
+			So(verifying.transport.TLSClientConfig.InsecureSkipVerify, ShouldBeFalse)
+
+			again, err := ds.GetInsecureHttpClient()
+			So(err, ShouldBeNil)
+			So(again.Transport, ShouldEqual, tr)
+		})
+	})
+
+	Convey("When caching a datasource proxy with connection timeouts specified", t, func() {
+		clearDSProxyCache()
+
+		json := simplejson.New()
+		json.Set("dialTimeout", 5)
+		json.Set("tlsHandshakeTimeout", 3)
+
+		ds := DataSource{
+			Id:       1,
+			Url:      "http://k8s:8001",
+			Type:     "Kubernetes",
+			JsonData: json,
+		}
+
+		tr, err := ds.GetHttpTransport()
+		So(err, ShouldBeNil)
+
+		Convey("Should use the configured timeouts", func() {
+			So(tr.dialer.Timeout, ShouldEqual, 5*time.Second)
+			So(tr.transport.TLSHandshakeTimeout, ShouldEqual, 3*time.Second)
+		})
+
+		Convey("Should fall back to the defaults when not configured", func() {
+			ds.JsonData = simplejson.New()
+			So(ds.getTimeout("dialTimeout", time.Second), ShouldEqual, time.Second)
+		})
+	})
+
+	Convey("When caching a datasource proxy without connection timeouts", t, func() {
+		clearDSProxyCache()
+		origTimeout := setting.DataProxyTimeout
+		setting.DataProxyTimeout = 42
+		defer func() { setting.DataProxyTimeout = origTimeout }()
+
+		ds := DataSource{
+			Id:       1,
+			Url:      "http://k8s:8001",
+			Type:     "Kubernetes",
+			JsonData: simplejson.New(),
+		}
+
+		Convey("Should keep the data proxy timeouts for most datasources", func() {
+			tr, err := ds.GetHttpTransport()
+			So(err, ShouldBeNil)
+			So(tr.dialer.Timeout, ShouldEqual, 42*time.Second)
+			So(tr.transport.TLSHandshakeTimeout, ShouldEqual, 10*time.Second)
+		})
+
+		Convey("Should default both timeouts of OpenTSDB datasources to 5 seconds", func() {
+			ds.Type = DS_OPENTSDB
+			tr, err := ds.GetHttpTransport()
+			So(err, ShouldBeNil)
+			So(tr.dialer.Timeout, ShouldEqual, 5*time.Second)
+			So(tr.transport.TLSHandshakeTimeout, ShouldEqual, 5*time.Second)
+		})
+	})
+
 	Convey("When caching a datasource proxy with custom headers specified", t, func() {
 		clearDSProxyCache()
 
@@ -299,6 +397,7 @@ func clearDSProxyCache() {
 	defer ptc.Unlock()
 
 	ptc.cache = make(map[int64]cachedTransport)
+	ptc.insecure = make(map[int64]cachedTransport)
 }
 
 const caCert string = `-----BEGIN CERTIFICATE-----
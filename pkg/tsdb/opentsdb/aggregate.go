@@ -0,0 +1,88 @@
+package opentsdb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// postAggregators combine the values the series of a query have at a
+// timestamp.
+var postAggregators = map[string]func(values []float64) float64{
+	"sum": func(values []float64) float64 {
+		total := 0.0
+		for _, value := range values {
+			total += value
+		}
+		return total
+	},
+	"avg": func(values []float64) float64 {
+		total := 0.0
+		for _, value := range values {
+			total += value
+		}
+		return total / float64(len(values))
+	},
+	"max": func(values []float64) float64 {
+		max := values[0]
+		for _, value := range values[1:] {
+			if value > max {
+				max = value
+			}
+		}
+		return max
+	},
+	"min": func(values []float64) float64 {
+		min := values[0]
+		for _, value := range values[1:] {
+			if value < min {
+				min = value
+			}
+		}
+		return min
+	},
+}
+
+// aggregateSeries computes a series named "<metric> (total)" combining the
+// points series have at the same timestamp with aggregator. Null points are
+// left out, timestamps without any value get a null point.
+func aggregateSeries(series tsdb.TimeSeriesSlice, metric string, aggregator string) (*tsdb.TimeSeries, error) {
+	aggregate, ok := postAggregators[aggregator]
+	if !ok {
+		return nil, fmt.Errorf("Invalid postAggregate %q: should be sum, avg, max or min", aggregator)
+	}
+
+	values := make(map[float64][]float64)
+	for _, s := range series {
+		for _, point := range s.Points {
+			timestamp := point[1].Float64
+			v := values[timestamp]
+			if point[0].Valid {
+				v = append(v, point[0].Float64)
+			}
+			values[timestamp] = v
+		}
+	}
+
+	timestamps := make([]float64, 0, len(values))
+	for timestamp := range values {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Float64s(timestamps)
+
+	total := &tsdb.TimeSeries{
+		Name:   metric + " (total)",
+		Tags:   map[string]string{},
+		Points: make(tsdb.TimeSeriesPoints, 0, len(timestamps)),
+	}
+	for _, timestamp := range timestamps {
+		value := null.FloatFromPtr(nil)
+		if v := values[timestamp]; len(v) > 0 {
+			value = null.FloatFrom(aggregate(v))
+		}
+		total.Points = append(total.Points, tsdb.NewTimePoint(value, timestamp))
+	}
+	return total, nil
+}
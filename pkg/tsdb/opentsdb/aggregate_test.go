@@ -0,0 +1,106 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbAggregate(t *testing.T) {
+	Convey("OpenTsdb aggregate testing", t, func() {
+
+		series := tsdb.TimeSeriesSlice{
+			{
+				Name: "cpu{host=web-1}",
+				Points: tsdb.TimeSeriesPoints{
+					tsdb.NewTimePoint(null.FloatFrom(1), 60000),
+					tsdb.NewTimePoint(null.FloatFrom(2), 120000),
+					tsdb.NewTimePoint(null.FloatFromPtr(nil), 180000),
+				},
+			},
+			{
+				Name: "cpu{host=web-2}",
+				Points: tsdb.TimeSeriesPoints{
+					tsdb.NewTimePoint(null.FloatFrom(3), 60000),
+					tsdb.NewTimePoint(null.FloatFrom(6), 120000),
+					tsdb.NewTimePoint(null.FloatFromPtr(nil), 180000),
+				},
+			},
+		}
+
+		Convey("Sum aligned series", func() {
+			total, err := aggregateSeries(series, "cpu", "sum")
+			So(err, ShouldBeNil)
+			So(total.Name, ShouldEqual, "cpu (total)")
+			So(total.Points, ShouldResemble, tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(4), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(8), 120000),
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 180000),
+			})
+		})
+
+		Convey("Combine series with other aggregators", func() {
+			for aggregator, expected := range map[string]float64{"avg": 4, "max": 6, "min": 2} {
+				total, err := aggregateSeries(series, "cpu", aggregator)
+				So(err, ShouldBeNil)
+				So(total.Points[1][0].Float64, ShouldEqual, expected)
+			}
+		})
+
+		Convey("Skip series lacking a timestamp", func() {
+			partial := append(series, &tsdb.TimeSeries{
+				Name:   "cpu{host=web-3}",
+				Points: tsdb.TimeSeriesPoints{tsdb.NewTimePoint(null.FloatFrom(10), 240000)},
+			})
+
+			total, err := aggregateSeries(partial, "cpu", "sum")
+			So(err, ShouldBeNil)
+			So(len(total.Points), ShouldEqual, 4)
+			So(total.Points[3], ShouldResemble, tsdb.NewTimePoint(null.FloatFrom(10), 240000))
+		})
+
+		Convey("Reject unknown aggregators", func() {
+			_, err := aggregateSeries(series, "cpu", "p99")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Keep the total last when sorting series", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[
+					{"metric": "cpu", "tags": {"host": "web-2"}, "dps": {"60": 3}},
+					{"metric": "cpu", "tags": {"host": "web-1"}, "dps": {"60": 1}}
+				]`)
+			}))
+			defer server.Close()
+
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{Url: server.URL, JsonData: simplejson.New()}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("tagsInName", true)
+			query.Model.Set("postAggregate", "sum")
+			query.Model.Set("sortSeries", true)
+			queryContext := &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1h", "now"),
+				Queries:   []*tsdb.Query{query},
+			}
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			names := []string{}
+			for _, s := range resp.Results["A"].Series {
+				names = append(names, s.Name)
+			}
+			So(names, ShouldResemble, []string{"cpu{host=web-1}", "cpu{host=web-2}", "cpu (total)"})
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// executeAnnotationQuery answers Grafana annotation queries with the
+// annotations of a metric in the time range, or the global annotations when
+// isGlobal is set. Every query gets a table of annotation events.
+func (e *OpenTsdbExecutor) executeAnnotationQuery(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, queryContext *tsdb.TsdbQuery) (*tsdb.Response, error) {
+	result := &tsdb.Response{
+		Results: make(map[string]*tsdb.QueryResult),
+	}
+
+	for _, query := range queryContext.Queries {
+		metric := query.Model.Get("metric").MustString()
+		if metric == "" {
+			return nil, fmt.Errorf("Annotation queries require a metric")
+		}
+
+		// OpenTSDB only returns annotations along with the data of a query.
+		data := OpenTsdbQuery{
+			Start:             queryContext.TimeRange.GetFromAsMsEpoch(),
+			End:               queryContext.TimeRange.GetToAsMsEpoch(),
+			Queries:           []map[string]interface{}{{"metric": metric, "aggregator": "sum"}},
+			GlobalAnnotations: query.Model.Get("isGlobal").MustBool(),
+		}
+
+		headers, err := queryHeaders(query)
+		if err != nil {
+			return nil, err
+		}
+		req, err := e.createRequest(ctx, dsInfo, data, headers)
+		if err != nil {
+			return nil, err
+		}
+		res, err := doRequest(ctx, dsInfo, httpClient, req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := readResponse(dsInfo, res)
+		if err != nil {
+			return nil, err
+		}
+		responses, err := unmarshalResponses(replaceNonFiniteValues(body), responseFieldNames(dsInfo))
+		if err != nil {
+			plog.Info("Failed to unmarshal opentsdb response", "error", err, "status", res.Status, "body", string(body))
+			return nil, err
+		}
+
+		annotations := seriesAnnotations(responses)
+		if data.GlobalAnnotations {
+			annotations = nil
+			if len(responses) > 0 {
+				annotations = responses[0].GlobalAnnotations
+			}
+		}
+		annotations = filterAnnotations(annotations, query.Model.Get("annotationFilter").MustMap())
+
+		queryRes := &tsdb.QueryResult{Meta: simplejson.New(), RefId: query.RefId}
+		queryRes.Tables = append(queryRes.Tables, annotationEvents(annotations))
+		result.Results[query.RefId] = queryRes
+	}
+
+	return result, nil
+}
+
+// annotationEvents converts annotations to a table of Grafana annotation
+// events. Custom fields become tags like "env:prod".
+func annotationEvents(annotations []OpenTsdbAnnotation) *tsdb.Table {
+	table := &tsdb.Table{
+		Columns: []tsdb.TableColumn{{Text: "time"}, {Text: "timeEnd"}, {Text: "text"}, {Text: "tags"}},
+		Rows:    make([]tsdb.RowValues, 0, len(annotations)),
+	}
+
+	for _, annotation := range annotations {
+		start := annotation.StartTime * 1000
+		end := start
+		if annotation.EndTime > annotation.StartTime {
+			end = annotation.EndTime * 1000
+		}
+
+		tags := make([]string, 0, len(annotation.Custom))
+		for key, value := range annotation.Custom {
+			tags = append(tags, key+":"+value)
+		}
+		sort.Strings(tags)
+
+		table.Rows = append(table.Rows, tsdb.RowValues{start, end, annotation.Description, tags})
+	}
+	return table
+}
+
+// seriesAnnotations collects the annotations of the series of a query,
+// leaving out the global ones.
+func seriesAnnotations(responses []OpenTsdbResponse) []OpenTsdbAnnotation {
+	var annotations []OpenTsdbAnnotation
+	for _, response := range responses {
+		annotations = append(annotations, response.Annotations...)
+	}
+	return annotations
+}
+
+// responseAnnotations collects the annotations OpenTSDB returns along with
+// the series of a query. Global annotations are repeated in every response
+// element, so they're only collected once.
+func responseAnnotations(responses []OpenTsdbResponse) []OpenTsdbAnnotation {
+	var annotations []OpenTsdbAnnotation
+	for i, response := range responses {
+		annotations = append(annotations, response.Annotations...)
+		if i == 0 {
+			annotations = append(annotations, response.GlobalAnnotations...)
+		}
+	}
+	return annotations
+}
+
+// filterAnnotations keeps the annotations whose custom fields match all of
+// the given values, e.g. {"env": "prod"}.
+func filterAnnotations(annotations []OpenTsdbAnnotation, filter map[string]interface{}) []OpenTsdbAnnotation {
+	if len(filter) == 0 {
+		return annotations
+	}
+
+	filtered := make([]OpenTsdbAnnotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		if matchesCustomFields(annotation, filter) {
+			filtered = append(filtered, annotation)
+		}
+	}
+	return filtered
+}
+
+func matchesCustomFields(annotation OpenTsdbAnnotation, filter map[string]interface{}) bool {
+	for field, expected := range filter {
+		value, ok := annotation.Custom[field]
+		if !ok || value != fmt.Sprint(expected) {
+			return false
+		}
+	}
+	return true
+}
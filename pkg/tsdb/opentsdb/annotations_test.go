@@ -0,0 +1,145 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbAnnotations(t *testing.T) {
+	Convey("OpenTsdb annotations testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{
+			Model: simplejson.New(),
+		}
+		data := OpenTsdbQuery{
+			Start:   60000,
+			End:     120000,
+			Queries: []map[string]interface{}{{"metric": "deploys"}},
+		}
+		response := `[{
+			"metric": "deploys",
+			"dps": {"60": 1},
+			"annotations": [
+				{"description": "web deploy", "startTime": 60, "custom": {"env": "prod", "team": "web"}},
+				{"description": "staging deploy", "startTime": 90, "custom": {"env": "staging"}},
+				{"description": "no custom fields", "startTime": 100}
+			]
+		}]`
+
+		Convey("Should return all annotations without filter", func() {
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+
+			annotations := queryRes.Meta.Get("annotations").Interface().([]OpenTsdbAnnotation)
+			So(len(annotations), ShouldEqual, 3)
+		})
+
+		Convey("Should filter annotations by custom fields", func() {
+			query.Model.Set("annotationFilter", map[string]interface{}{"env": "prod"})
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+
+			annotations := queryRes.Meta.Get("annotations").Interface().([]OpenTsdbAnnotation)
+			So(len(annotations), ShouldEqual, 1)
+			So(annotations[0].Description, ShouldEqual, "web deploy")
+		})
+
+		Convey("Should require every custom field to match", func() {
+			query.Model.Set("annotationFilter", map[string]interface{}{"env": "prod", "team": "db"})
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+
+			_, ok := queryRes.Meta.CheckGet("annotations")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should only collect global annotations once", func() {
+			annotations := responseAnnotations([]OpenTsdbResponse{
+				{Metric: "a", GlobalAnnotations: []OpenTsdbAnnotation{{Description: "outage"}}},
+				{Metric: "b", GlobalAnnotations: []OpenTsdbAnnotation{{Description: "outage"}}},
+			})
+			So(len(annotations), ShouldEqual, 1)
+		})
+
+		Convey("Answer annotation queries with annotation events", func() {
+			var requests []OpenTsdbQuery
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var data OpenTsdbQuery
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				requests = append(requests, data)
+				fmt.Fprint(w, `[{
+					"metric": "deploys",
+					"dps": {"60": 1},
+					"annotations": [
+						{"description": "web deploy", "startTime": 60, "endTime": 90, "custom": {"team": "web", "env": "prod"}},
+						{"description": "db deploy", "startTime": 100}
+					],
+					"globalAnnotations": [{"description": "outage", "startTime": 70}]
+				}]`)
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{RefId: "Anno", Model: simplejson.New()}
+			query.Model.Set("type", "annotationQuery")
+			query.Model.Set("metric", "deploys")
+			queryContext := &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("60000", "120000"),
+				Queries:   []*tsdb.Query{query},
+			}
+
+			Convey("Should return the annotations of the metric", func() {
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				So(requests[0].GlobalAnnotations, ShouldBeFalse)
+				So(requests[0].Queries[0]["metric"], ShouldEqual, "deploys")
+
+				table := resp.Results["Anno"].Tables[0]
+				So(table.Columns, ShouldResemble, []tsdb.TableColumn{{Text: "time"}, {Text: "timeEnd"}, {Text: "text"}, {Text: "tags"}})
+				So(table.Rows, ShouldResemble, []tsdb.RowValues{
+					{int64(60000), int64(90000), "web deploy", []string{"env:prod", "team:web"}},
+					{int64(100000), int64(100000), "db deploy", []string{}},
+				})
+			})
+
+			Convey("Should return global annotations when requested", func() {
+				query.Model.Set("isGlobal", true)
+
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				So(requests[0].GlobalAnnotations, ShouldBeTrue)
+				So(resp.Results["Anno"].Tables[0].Rows, ShouldResemble, []tsdb.RowValues{
+					{int64(70000), int64(70000), "outage", []string{}},
+				})
+			})
+
+			Convey("Should require a metric", func() {
+				query.Model.Del("metric")
+
+				_, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,82 @@
+package opentsdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// coarseIntervals are the downsample intervals the coarsen bucketPolicy picks
+// from, finest first. Coarser intervals are rounded up to whole weeks.
+var coarseIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// bucketCount returns the number of downsample buckets of width interval
+// between start and end, in milliseconds.
+func bucketCount(start int64, end int64, interval time.Duration) int64 {
+	step := interval.Milliseconds()
+	if step <= 0 || end <= start {
+		return 1
+	}
+	return (end - start + step - 1) / step
+}
+
+// coarserInterval returns the finest of coarseIntervals splitting the range
+// between start and end into at most maxBuckets buckets.
+func coarserInterval(start int64, end int64, maxBuckets int64) time.Duration {
+	for _, interval := range coarseIntervals {
+		if bucketCount(start, end, interval) <= maxBuckets {
+			return interval
+		}
+	}
+	week := coarseIntervals[len(coarseIntervals)-1]
+	weeks := (bucketCount(start, end, week) + maxBuckets - 1) / maxBuckets
+	return time.Duration(weeks) * week
+}
+
+// limitBuckets enforces the datasource's maxBuckets on the downsampled sub
+// queries of data. Depending on its bucketPolicy, sub queries asking for more
+// buckets fail, or with coarsen get the coarser interval returned warnings
+// tell about. Sub queries downsampled over calendar intervals or the whole
+// range are left alone.
+func limitBuckets(dsInfo *models.DataSource, data OpenTsdbQuery) ([]string, error) {
+	maxBuckets := dsInfo.JsonData.Get("maxBuckets").MustInt64()
+	if maxBuckets <= 0 {
+		return nil, nil
+	}
+
+	policy := dsInfo.JsonData.Get("bucketPolicy").MustString("error")
+	if policy != "error" && policy != "coarsen" {
+		return nil, fmt.Errorf("Invalid bucketPolicy %q: should be error or coarsen", policy)
+	}
+
+	var warnings []string
+	for _, subQuery := range data.Queries {
+		interval, err := downsampleInterval(subQuery)
+		if err != nil {
+			continue
+		}
+		buckets := bucketCount(data.Start, data.End, interval)
+		if buckets <= maxBuckets {
+			continue
+		}
+
+		if policy == "error" {
+			return nil, fmt.Errorf("Downsample interval %s of %v asks for %d buckets, more than the maxBuckets limit of %d. Use a coarser interval or a shorter time range",
+				formatInterval(interval), subQuery["metric"], buckets, maxBuckets)
+		}
+
+		coarser := coarserInterval(data.Start, data.End, maxBuckets)
+		downsample := subQuery["downsample"].(string)
+		subQuery["downsample"] = formatInterval(coarser) + downsample[strings.Index(downsample+"-", "-"):]
+		warnings = append(warnings, fmt.Sprintf("Downsample interval of %v coarsened from %s to %s to stay within %d buckets",
+			subQuery["metric"], formatInterval(interval), formatInterval(coarser), maxBuckets))
+	}
+	return warnings, nil
+}
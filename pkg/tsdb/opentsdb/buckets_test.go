@@ -0,0 +1,82 @@
+package opentsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbBuckets(t *testing.T) {
+	Convey("OpenTsdb downsample bucket testing", t, func() {
+
+		day := int64(24 * time.Hour / time.Millisecond)
+
+		Convey("Count buckets", func() {
+			So(bucketCount(0, 90*day, time.Second), ShouldEqual, 90*24*3600)
+			So(bucketCount(0, 3600*1000, 5*time.Minute), ShouldEqual, 12)
+			So(bucketCount(0, 3600*1000+1, 5*time.Minute), ShouldEqual, 13)
+			So(bucketCount(1000, 1000, time.Minute), ShouldEqual, 1)
+		})
+
+		Convey("Pick coarser intervals", func() {
+			So(coarserInterval(0, 90*day, 10000), ShouldEqual, 30*time.Minute)
+			So(coarserInterval(0, 3600*1000, 100), ShouldEqual, time.Minute)
+			So(coarserInterval(0, 3650*day, 10), ShouldEqual, 53*7*24*time.Hour)
+		})
+
+		Convey("Limit buckets of sub queries", func() {
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			data := func() OpenTsdbQuery {
+				return OpenTsdbQuery{
+					Start: 0,
+					End:   90 * day,
+					Queries: []map[string]interface{}{
+						{"metric": "cpu", "downsample": "1s-avg-nan"},
+						{"metric": "mem", "downsample": "1d-max"},
+						{"metric": "disk", "downsample": "0all-sum"},
+						{"metric": "net"},
+					},
+				}
+			}
+
+			Convey("Should not limit by default", func() {
+				warnings, err := limitBuckets(dsInfo, data())
+				So(err, ShouldBeNil)
+				So(warnings, ShouldBeEmpty)
+			})
+
+			Convey("Should reject too many buckets", func() {
+				dsInfo.JsonData.Set("maxBuckets", 10000)
+
+				_, err := limitBuckets(dsInfo, data())
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "Downsample interval 1s of cpu asks for 7776000 buckets")
+			})
+
+			Convey("Should coarsen intervals with too many buckets", func() {
+				dsInfo.JsonData.Set("maxBuckets", 10000)
+				dsInfo.JsonData.Set("bucketPolicy", "coarsen")
+				query := data()
+
+				warnings, err := limitBuckets(dsInfo, query)
+				So(err, ShouldBeNil)
+				So(warnings, ShouldResemble, []string{"Downsample interval of cpu coarsened from 1s to 30m to stay within 10000 buckets"})
+				So(query.Queries[0]["downsample"], ShouldEqual, "30m-avg-nan")
+				So(query.Queries[1]["downsample"], ShouldEqual, "1d-max")
+				So(query.Queries[2]["downsample"], ShouldEqual, "0all-sum")
+				So(query.Queries[3], ShouldNotContainKey, "downsample")
+			})
+
+			Convey("Should reject unknown policies", func() {
+				dsInfo.JsonData.Set("maxBuckets", 10000)
+				dsInfo.JsonData.Set("bucketPolicy", "ignore")
+
+				_, err := limitBuckets(dsInfo, data())
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
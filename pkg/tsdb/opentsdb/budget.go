@@ -0,0 +1,18 @@
+package opentsdb
+
+import (
+	"context"
+	"time"
+)
+
+// targetContext derives the context of the next of outstanding targets from
+// ctx. When ctx has a deadline, each target gets an equal share of the time
+// left, so that a slow target can't use up the time of the ones after it.
+// Time a target doesn't use is left to the others.
+func targetContext(ctx context.Context, outstanding int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || outstanding <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(outstanding))
+}
@@ -0,0 +1,87 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbBudget(t *testing.T) {
+	Convey("OpenTsdb deadline budget testing", t, func() {
+
+		Convey("Share the time left among outstanding targets", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			targetCtx, targetCancel := targetContext(ctx, 4)
+			defer targetCancel()
+			deadline, ok := targetCtx.Deadline()
+			So(ok, ShouldBeTrue)
+			So(time.Until(deadline), ShouldBeLessThanOrEqualTo, 250*time.Millisecond)
+			So(time.Until(deadline), ShouldBeGreaterThan, 200*time.Millisecond)
+
+			lastCtx, lastCancel := targetContext(ctx, 1)
+			defer lastCancel()
+			lastDeadline, _ := lastCtx.Deadline()
+			parentDeadline, _ := ctx.Deadline()
+			So(lastDeadline, ShouldEqual, parentDeadline)
+		})
+
+		Convey("Leave targets without deadline unbounded", func() {
+			targetCtx, cancel := targetContext(context.Background(), 3)
+			defer cancel()
+
+			_, ok := targetCtx.Deadline()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Fail only the slow target of a panel with a tight deadline", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var data OpenTsdbQuery
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if data.Queries[0]["metric"] == "slow" {
+					<-r.Context().Done()
+					return
+				}
+				fmt.Fprintf(w, `[{"metric": %q, "dps": {"60": 1}}]`, data.Queries[0]["metric"])
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			var queries []*tsdb.Query
+			for _, metric := range []string{"slow", "cpu", "mem"} {
+				query := &tsdb.Query{RefId: metric, Model: simplejson.New()}
+				query.Model.Set("metric", metric)
+				query.Model.Set("aggregator", "sum")
+				queries = append(queries, query)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+			defer cancel()
+			exec := &OpenTsdbExecutor{}
+			resp, err := exec.Query(ctx, dsInfo, &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1h", "now"),
+				Queries:   queries,
+			})
+			So(err, ShouldBeNil)
+			So(resp.Results["slow"].Error, ShouldNotBeNil)
+			So(len(resp.Results["cpu"].Series), ShouldEqual, 1)
+			So(len(resp.Results["mem"].Series), ShouldEqual, 1)
+		})
+	})
+}
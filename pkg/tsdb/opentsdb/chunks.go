@@ -0,0 +1,83 @@
+package opentsdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// defaultChunkDuration is the chunk width of chunked queries that don't set
+// chunkDuration.
+const defaultChunkDuration = 24 * time.Hour
+
+// chunkDuration returns the width of the chunks a chunked query's time range
+// is split into, or zero when the query isn't chunked.
+func chunkDuration(query *tsdb.Query) (time.Duration, error) {
+	if !query.Model.Get("chunked").MustBool() {
+		return 0, nil
+	}
+
+	value := query.Model.Get("chunkDuration").MustString()
+	if value == "" {
+		return defaultChunkDuration, nil
+	}
+
+	chunk, err := time.ParseDuration(value)
+	if err != nil || chunk < time.Minute {
+		return 0, fmt.Errorf("Invalid chunkDuration %q: should be a duration of at least 1m like 24h", value)
+	}
+	return chunk, nil
+}
+
+// queryChunks splits the time range of data into consecutive chunks. Like
+// the time range, every chunk includes its end, which is the start of the
+// next chunk.
+func queryChunks(data OpenTsdbQuery, chunk time.Duration) []OpenTsdbQuery {
+	step := int64(chunk / time.Millisecond)
+
+	var chunks []OpenTsdbQuery
+	for start := data.Start; ; start += step {
+		chunkQuery := data
+		chunkQuery.Start = start
+		if start+step < data.End {
+			chunkQuery.End = start + step
+		}
+		chunks = append(chunks, chunkQuery)
+		if chunkQuery.End == data.End {
+			return chunks
+		}
+	}
+}
+
+// mergeChunks merges the results of the chunks of a query. The series of the
+// chunks are joined by name and tags, dropping the points repeated at chunk
+// boundaries in favor of non null ones. The meta of the first chunk is kept.
+func mergeChunks(results []*tsdb.QueryResult) *tsdb.QueryResult {
+	merged := tsdb.NewQueryResult()
+	merged.Meta = results[0].Meta
+
+	index := make(map[string]*tsdb.TimeSeries)
+	for _, result := range results {
+		for _, series := range result.Series {
+			key := seriesKey(series)
+			existing, ok := index[key]
+			if !ok {
+				index[key] = series
+				merged.Series = append(merged.Series, series)
+				continue
+			}
+
+			for _, point := range series.Points {
+				last := len(existing.Points) - 1
+				switch {
+				case last < 0 || point[1].Float64 > existing.Points[last][1].Float64:
+					existing.Points = append(existing.Points, point)
+				case point[1].Float64 == existing.Points[last][1].Float64 && !existing.Points[last][0].Valid:
+					existing.Points[last] = point
+				}
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,141 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbChunks(t *testing.T) {
+	Convey("OpenTsdb chunks testing", t, func() {
+
+		Convey("Parse chunk durations", func() {
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+
+			chunk, err := chunkDuration(query)
+			So(err, ShouldBeNil)
+			So(chunk, ShouldEqual, 0)
+
+			query.Model.Set("chunked", true)
+			chunk, err = chunkDuration(query)
+			So(err, ShouldBeNil)
+			So(chunk, ShouldEqual, 24*time.Hour)
+
+			query.Model.Set("chunkDuration", "6h")
+			chunk, err = chunkDuration(query)
+			So(err, ShouldBeNil)
+			So(chunk, ShouldEqual, 6*time.Hour)
+
+			query.Model.Set("chunkDuration", "1s")
+			_, err = chunkDuration(query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Split the time range into chunks", func() {
+			chunks := queryChunks(OpenTsdbQuery{Start: 0, End: 250000}, 100*time.Second)
+			So(len(chunks), ShouldEqual, 3)
+			So(chunks[0].Start, ShouldEqual, 0)
+			So(chunks[0].End, ShouldEqual, 100000)
+			So(chunks[1].Start, ShouldEqual, 100000)
+			So(chunks[1].End, ShouldEqual, 200000)
+			So(chunks[2].Start, ShouldEqual, 200000)
+			So(chunks[2].End, ShouldEqual, 250000)
+
+			chunks = queryChunks(OpenTsdbQuery{Start: 0, End: 50000}, 100*time.Second)
+			So(len(chunks), ShouldEqual, 1)
+			So(chunks[0].End, ShouldEqual, 50000)
+		})
+
+		Convey("Merge chunks deduplicating boundary points", func() {
+			first := tsdb.NewQueryResult()
+			first.Series = tsdb.TimeSeriesSlice{{
+				Name: "cpu",
+				Tags: map[string]string{"host": "a"},
+				Points: tsdb.TimeSeriesPoints{
+					tsdb.NewTimePoint(null.FloatFrom(1), 60000),
+					tsdb.NewTimePoint(null.FloatFrom(2), 120000),
+					tsdb.NewTimePoint(null.FloatFromPtr(nil), 180000),
+				},
+			}}
+			second := tsdb.NewQueryResult()
+			second.Series = tsdb.TimeSeriesSlice{
+				{
+					Name: "cpu",
+					Tags: map[string]string{"host": "a"},
+					Points: tsdb.TimeSeriesPoints{
+						tsdb.NewTimePoint(null.FloatFrom(3), 180000),
+						tsdb.NewTimePoint(null.FloatFrom(4), 240000),
+					},
+				},
+				{
+					Name: "cpu",
+					Tags: map[string]string{"host": "b"},
+					Points: tsdb.TimeSeriesPoints{
+						tsdb.NewTimePoint(null.FloatFrom(5), 240000),
+					},
+				},
+			}
+
+			merged := mergeChunks([]*tsdb.QueryResult{first, second})
+			So(len(merged.Series), ShouldEqual, 2)
+			So(merged.Series[0].Points, ShouldResemble, tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(1), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(2), 120000),
+				tsdb.NewTimePoint(null.FloatFrom(3), 180000),
+				tsdb.NewTimePoint(null.FloatFrom(4), 240000),
+			})
+			So(merged.Series[1].Tags["host"], ShouldEqual, "b")
+		})
+
+		Convey("Query chunked time ranges", func() {
+			var ranges [][2]int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var data OpenTsdbQuery
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				ranges = append(ranges, [2]int64{data.Start, data.End})
+				fmt.Fprintf(w, `[{"metric": "cpu", "dps": {"%d": 1, "%d": 2}}]`, data.Start/1000, data.End/1000)
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("chunked", true)
+			query.Model.Set("chunkDuration", "1h")
+
+			resp, err := (&OpenTsdbExecutor{}).Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1546300800000", "1546308000000"),
+				Queries:   []*tsdb.Query{query},
+			})
+			So(err, ShouldBeNil)
+			So(ranges, ShouldResemble, [][2]int64{
+				{1546300800000, 1546304400000},
+				{1546304400000, 1546308000000},
+			})
+
+			series := resp.Results["A"].Series
+			So(len(series), ShouldEqual, 1)
+			So(len(series[0].Points), ShouldEqual, 3)
+			So(series[0].Points[1][1].Float64, ShouldEqual, 1546304400000)
+		})
+	})
+}
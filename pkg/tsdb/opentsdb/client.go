@@ -0,0 +1,136 @@
+package opentsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// maxRetryAfter bounds how long a rate limited request waits for a retry,
+// longer delays asked for by the server fail the query right away.
+const maxRetryAfter = 10 * time.Second
+
+var errRateLimited = errors.New("OpenTSDB rate limited this query")
+
+// newRequest builds a request to OpenTSDB carrying the credentials of the
+// datasource and the headers of the query it's sent for. Every request is
+// built here, so that none of them lacks the httpHeaders of its query.
+func newRequest(dsInfo *models.DataSource, method string, u string, body io.Reader, headers http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		plog.Info("Failed to create request", "error", err)
+		return nil, fmt.Errorf("Failed to create request. error: %v", err)
+	}
+	applyAuth(dsInfo, req)
+	applyHeaders(req, headers)
+	return req, nil
+}
+
+// doRequest sends req to OpenTSDB. Requests rejected with 429 Too Many
+// Requests are retried after the delay announced in Retry-After, as long as
+// the datasource's rateLimitRetries budget isn't used up.
+func doRequest(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	retries := dsInfo.JsonData.Get("rateLimitRetries").MustInt(1)
+
+	for attempt := 0; ; attempt++ {
+		res, err := ctxhttp.Do(ctx, httpClient, req)
+		if err != nil {
+			return nil, classifyConnectionError(req, err)
+		}
+
+		if res.StatusCode != http.StatusTooManyRequests {
+			return res, nil
+		}
+
+		// Drain the body so the connection can be reused for the retry.
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		delay, ok := retryAfter(res.Header.Get("Retry-After"), time.Now())
+		if attempt >= retries || !ok || delay > maxRetryAfter || (req.GetBody == nil && req.Body != nil) {
+			plog.Info("Request rate limited", "status", res.Status, "attempts", attempt+1)
+			return nil, errRateLimited
+		}
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// connectionError is returned for requests that didn't get a response. It
+// tells a mistyped host from a down or a slow server, and unwraps to the
+// error of the HTTP client.
+type connectionError struct {
+	message string
+	err     error
+}
+
+func (e *connectionError) Error() string {
+	return e.message
+}
+
+func (e *connectionError) Unwrap() error {
+	return e.err
+}
+
+// classifyConnectionError explains DNS failures, refused connections and
+// timeouts of req. Other errors, such as canceled queries, are returned
+// unchanged.
+func classifyConnectionError(req *http.Request, err error) error {
+	var dnsError *net.DNSError
+	var netError net.Error
+	switch {
+	case errors.As(err, &dnsError):
+		return &connectionError{fmt.Sprintf("Could not resolve OpenTSDB host %s. Check the URL of the datasource", req.URL.Hostname()), err}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return &connectionError{fmt.Sprintf("OpenTSDB at %s refused the connection. Check that it's running and listening on that port", req.URL.Host), err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return &connectionError{fmt.Sprintf("OpenTSDB at %s didn't respond in time. It may be overloaded, or the query too expensive", req.URL.Host), err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,210 @@
+package opentsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbClient(t *testing.T) {
+	Convey("OpenTsdb client testing", t, func() {
+
+		Convey("Parse Retry-After", func() {
+			now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			delay, ok := retryAfter("3", now)
+			So(ok, ShouldBeTrue)
+			So(delay, ShouldEqual, 3*time.Second)
+
+			delay, ok = retryAfter("Wed, 01 Jan 2020 00:00:05 GMT", now)
+			So(ok, ShouldBeTrue)
+			So(delay, ShouldEqual, 5*time.Second)
+
+			_, ok = retryAfter("", now)
+			So(ok, ShouldBeFalse)
+
+			_, ok = retryAfter("soon", now)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Handle rate limiting", func() {
+			var bodies []string
+			limited := 1
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if len(bodies) <= limited {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				fmt.Fprint(w, "[]")
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			newRequest := func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"queries": []}`))
+				So(err, ShouldBeNil)
+				return req
+			}
+
+			Convey("Should retry within the budget", func() {
+				res, err := doRequest(context.Background(), dsInfo, http.DefaultClient, newRequest())
+				So(err, ShouldBeNil)
+				defer res.Body.Close()
+				So(res.StatusCode, ShouldEqual, http.StatusOK)
+				So(len(bodies), ShouldEqual, 2)
+				So(bodies[1], ShouldEqual, bodies[0])
+			})
+
+			Convey("Should fail once the budget is used up", func() {
+				limited = 3
+				dsInfo.JsonData.Set("rateLimitRetries", 2)
+
+				_, err := doRequest(context.Background(), dsInfo, http.DefaultClient, newRequest())
+				So(err, ShouldEqual, errRateLimited)
+				So(len(bodies), ShouldEqual, 3)
+			})
+
+			Convey("Should not retry without a budget", func() {
+				dsInfo.JsonData.Set("rateLimitRetries", 0)
+
+				_, err := doRequest(context.Background(), dsInfo, http.DefaultClient, newRequest())
+				So(err, ShouldEqual, errRateLimited)
+				So(len(bodies), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Abort in-flight requests on cancellation", func() {
+			aborted := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[{"metric": "cpu", "dps": {`)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+				close(aborted)
+			}))
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			goroutines := runtime.NumGoroutine()
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			So(err, ShouldBeNil)
+			res, err := doRequest(ctx, dsInfo, server.Client(), req)
+			So(err, ShouldBeNil)
+			body := &closeRecorder{ReadCloser: res.Body}
+			res.Body = body
+
+			// Cancel while the body is still being sent.
+			cancel()
+
+			_, err = readResponse(dsInfo, res)
+			So(err, ShouldNotBeNil)
+			So(body.closed, ShouldBeTrue)
+
+			select {
+			case <-aborted:
+			case <-time.After(5 * time.Second):
+				t.Fatal("request to OpenTSDB wasn't aborted")
+			}
+			server.Close()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(runtime.NumGoroutine(), ShouldBeLessThanOrEqualTo, goroutines)
+		})
+
+		Convey("Classify connection failures", func() {
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			send := func(ctx context.Context, url string, roundTrip func(*http.Request) (*http.Response, error)) error {
+				req, err := http.NewRequest(http.MethodGet, url, nil)
+				So(err, ShouldBeNil)
+				client := &http.Client{}
+				if roundTrip != nil {
+					client.Transport = roundTripFunc(roundTrip)
+				}
+				_, err = doRequest(ctx, dsInfo, client, req)
+				return err
+			}
+
+			Convey("Should report DNS failures", func() {
+				err := send(context.Background(), "http://opentsdb.example:4242/api/query", func(*http.Request) (*http.Response, error) {
+					return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "opentsdb.example", IsNotFound: true}}
+				})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Could not resolve OpenTSDB host opentsdb.example. Check the URL of the datasource")
+			})
+
+			Convey("Should report refused connections", func() {
+				server := httptest.NewServer(http.NotFoundHandler())
+				url := server.URL
+				server.Close()
+
+				err := send(context.Background(), url, nil)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "OpenTSDB at "+strings.TrimPrefix(url, "http://")+" refused the connection")
+			})
+
+			Convey("Should report timeouts", func() {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					<-r.Context().Done()
+				}))
+				defer server.Close()
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				err := send(ctx, server.URL, nil)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEndWith, "didn't respond in time. It may be overloaded, or the query too expensive")
+				So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			})
+
+			Convey("Should keep other errors", func() {
+				err := send(context.Background(), "http://localhost:4242", func(*http.Request) (*http.Response, error) {
+					return nil, io.ErrUnexpectedEOF
+				})
+				So(errors.Is(err, io.ErrUnexpectedEOF), ShouldBeTrue)
+				_, ok := err.(*connectionError)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return r.ReadCloser.Close()
+}
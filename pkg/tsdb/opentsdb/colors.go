@@ -0,0 +1,14 @@
+package opentsdb
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// seriesColor derives a stable color from a tag value, so that the series of
+// the same host, say, get the same color hint on every panel.
+func seriesColor(value string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(value))
+	return fmt.Sprintf("hsl(%d, 70%%, 50%%)", hash.Sum32()%360)
+}
@@ -0,0 +1,18 @@
+package opentsdb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbColors(t *testing.T) {
+	Convey("OpenTsdb colors testing", t, func() {
+
+		Convey("Derive stable colors from tag values", func() {
+			So(seriesColor("web-1"), ShouldEqual, seriesColor("web-1"))
+			So(seriesColor("web-1"), ShouldNotEqual, seriesColor("web-2"))
+			So(seriesColor("web-1"), ShouldStartWith, "hsl(")
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package opentsdb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// querySlotSet counts the running queries of a datasource. released is
+// closed and replaced whenever one of them finishes, waking up the queries
+// waiting for a slot.
+type querySlotSet struct {
+	running  int
+	released chan struct{}
+}
+
+// querySlots holds the slot set of each datasource id that has queries
+// running. Executors are created for every request, so the sets have to
+// outlive them.
+var querySlots = struct {
+	sync.Mutex
+	sets map[int64]*querySlotSet
+}{sets: make(map[int64]*querySlotSet)}
+
+// acquireQuerySlot waits until fewer than the datasource's
+// maxConcurrentQueries queries are running, or ctx is done. The returned
+// function releases the slot again. The limit is checked against all
+// running queries, so lowering it holds back new queries until enough of
+// the running ones finish.
+func acquireQuerySlot(ctx context.Context, dsInfo *models.DataSource) (func(), error) {
+	limit := dsInfo.JsonData.Get("maxConcurrentQueries").MustInt()
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	for {
+		querySlots.Lock()
+		set, ok := querySlots.sets[dsInfo.Id]
+		if !ok {
+			set = &querySlotSet{released: make(chan struct{})}
+			querySlots.sets[dsInfo.Id] = set
+		}
+		if set.running < limit {
+			set.running++
+			querySlots.Unlock()
+			var once sync.Once
+			return func() { once.Do(func() { releaseQuerySlot(dsInfo.Id, set) }) }, nil
+		}
+		released := set.released
+		querySlots.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// releaseQuerySlot gives back a slot of set, dropping the set once no query
+// of the datasource is running anymore.
+func releaseQuerySlot(id int64, set *querySlotSet) {
+	querySlots.Lock()
+	defer querySlots.Unlock()
+
+	set.running--
+	close(set.released)
+	set.released = make(chan struct{})
+	if set.running == 0 && querySlots.sets[id] == set {
+		delete(querySlots.sets, id)
+	}
+}
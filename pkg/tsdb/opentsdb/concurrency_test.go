@@ -0,0 +1,90 @@
+package opentsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbConcurrency(t *testing.T) {
+	Convey("OpenTsdb concurrency testing", t, func() {
+
+		dsInfo := &models.DataSource{
+			Id:       160,
+			JsonData: simplejson.New(),
+		}
+
+		Convey("Should not limit queries by default", func() {
+			for i := 0; i < 3; i++ {
+				_, err := acquireQuerySlot(context.Background(), dsInfo)
+				So(err, ShouldBeNil)
+			}
+		})
+
+		Convey("Should make queries beyond the limit wait", func() {
+			dsInfo.JsonData.Set("maxConcurrentQueries", 2)
+
+			releaseA, err := acquireQuerySlot(context.Background(), dsInfo)
+			So(err, ShouldBeNil)
+			releaseB, err := acquireQuerySlot(context.Background(), dsInfo)
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = acquireQuerySlot(ctx, dsInfo)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+
+			// Other datasources have their own slots.
+			release, err := acquireQuerySlot(context.Background(), &models.DataSource{Id: 161, JsonData: dsInfo.JsonData})
+			So(err, ShouldBeNil)
+			release()
+
+			acquired := make(chan struct{})
+			go func() {
+				release, err := acquireQuerySlot(context.Background(), dsInfo)
+				if err == nil {
+					release()
+				}
+				close(acquired)
+			}()
+			releaseA()
+
+			select {
+			case <-acquired:
+			case <-time.After(5 * time.Second):
+				t.Fatal("waiting query didn't get the released slot")
+			}
+			releaseB()
+		})
+
+		Convey("Should enforce a lowered limit against the running queries", func() {
+			dsInfo.JsonData.Set("maxConcurrentQueries", 2)
+			releaseA, err := acquireQuerySlot(context.Background(), dsInfo)
+			So(err, ShouldBeNil)
+			releaseB, err := acquireQuerySlot(context.Background(), dsInfo)
+			So(err, ShouldBeNil)
+
+			dsInfo.JsonData.Set("maxConcurrentQueries", 1)
+			releaseA()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = acquireQuerySlot(ctx, dsInfo)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+
+			releaseB()
+			release, err := acquireQuerySlot(context.Background(), dsInfo)
+			So(err, ShouldBeNil)
+			release()
+
+			querySlots.Lock()
+			_, ok := querySlots.sets[dsInfo.Id]
+			querySlots.Unlock()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
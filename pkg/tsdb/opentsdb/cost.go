@@ -0,0 +1,66 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// defaultCostResolution is the point interval assumed for sub queries
+// without downsampling when the datasource sets no minDownsampleInterval.
+const defaultCostResolution = time.Minute
+
+// estimateCost estimates how many data points the sub queries of data
+// return, from the number of time series a lookup finds for each sub query
+// and the number of intervals in the time range. Tag filters other than the
+// tags of versions before 2.2 aren't taken into account.
+func (e *OpenTsdbExecutor) estimateCost(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, data OpenTsdbQuery) (int64, error) {
+	resolution := defaultCostResolution
+	if value := dsInfo.JsonData.Get("minDownsampleInterval").MustString(); value != "" {
+		interval, err := parseInterval(value)
+		if err != nil {
+			return 0, err
+		}
+		resolution = interval
+	}
+
+	var cost int64
+	for _, subQuery := range data.Queries {
+		metric, _ := subQuery["metric"].(string)
+		tags, _ := subQuery["tags"].(map[string]interface{})
+		page, err := e.lookupPage(ctx, dsInfo, httpClient, headers, lookupTerm(metric, tags), 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		series := page.TotalResults
+		if series < len(page.Results) {
+			series = len(page.Results)
+		}
+
+		cost += int64(series) * intervalCount(data, subQuery, resolution)
+	}
+	return cost, nil
+}
+
+// intervalCount returns the number of points a series of subQuery has at
+// most in the time range of data.
+func intervalCount(data OpenTsdbQuery, subQuery map[string]interface{}, resolution time.Duration) int64 {
+	interval := resolution
+	if downsample, ok := subQuery["downsample"].(string); ok {
+		if strings.HasPrefix(downsample, "0all") {
+			return 1
+		}
+		if parsed, err := downsampleInterval(subQuery); err == nil {
+			interval = parsed
+		}
+	}
+
+	count := (data.End - data.Start) / interval.Milliseconds()
+	if count < 1 {
+		return 1
+	}
+	return count
+}
@@ -0,0 +1,87 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbCost(t *testing.T) {
+	Convey("OpenTsdb query cost testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		var queried bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/search/lookup" {
+				fmt.Fprint(w, `{"results": [{"tsuid": "0001", "metric": "sys.cpu", "tags": {"host": "web01"}}], "totalResults": 10}`)
+				return
+			}
+			queried = true
+			fmt.Fprint(w, `[]`)
+		}))
+		defer server.Close()
+
+		dsInfo := &models.DataSource{
+			Url:      server.URL,
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+		query.Model.Set("metric", "sys.cpu")
+		query.Model.Set("aggregator", "sum")
+		queryContext := &tsdb.TsdbQuery{
+			TimeRange: tsdb.NewTimeRange("1h", "now"),
+			Queries:   []*tsdb.Query{query},
+		}
+
+		Convey("Should estimate series times intervals", func() {
+			data := OpenTsdbQuery{
+				Start:   0,
+				End:     3600 * 1000,
+				Queries: []map[string]interface{}{{"metric": "sys.cpu", "downsample": "5m-avg"}, {"metric": "sys.mem"}},
+			}
+
+			cost, err := exec.estimateCost(context.Background(), dsInfo, http.DefaultClient, nil, data)
+			So(err, ShouldBeNil)
+			So(cost, ShouldEqual, 10*12+10*60)
+		})
+
+		Convey("Should count one interval for 0all downsampling and short ranges", func() {
+			data := OpenTsdbQuery{Start: 0, End: 3600 * 1000}
+			So(intervalCount(data, map[string]interface{}{"downsample": "0all-sum"}, defaultCostResolution), ShouldEqual, 1)
+
+			data.End = 1000
+			So(intervalCount(data, map[string]interface{}{}, defaultCostResolution), ShouldEqual, 1)
+		})
+
+		Convey("Should not estimate without a limit", func() {
+			_, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(queried, ShouldBeTrue)
+		})
+
+		Convey("Should run queries within the limit", func() {
+			dsInfo.JsonData.Set("maxQueryCost", 600)
+
+			_, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(queried, ShouldBeTrue)
+		})
+
+		Convey("Should refuse queries above the limit", func() {
+			dsInfo.JsonData.Set("maxQueryCost", 599)
+
+			_, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldNotBeNil)
+			So(strings.HasPrefix(err.Error(), "Query too expensive"), ShouldBeTrue)
+			So(queried, ShouldBeFalse)
+		})
+	})
+}
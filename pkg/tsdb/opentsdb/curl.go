@@ -0,0 +1,56 @@
+package opentsdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// credentialPlaceholder replaces credentials in curl commands.
+const credentialPlaceholder = "<redacted>"
+
+// curlCommand formats req as a curl command reproducing it outside Grafana.
+// Headers that may carry credentials get a placeholder value, and the user
+// info of the URL is left out.
+func curlCommand(req *http.Request) (string, error) {
+	parts := []string{"curl", "-X", req.Method}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if isSensitiveHeader(name) {
+				value = credentialPlaceholder
+			}
+			parts = append(parts, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "--data-binary", shellQuote(string(data)))
+	}
+
+	u := *req.URL
+	u.User = nil
+	parts = append(parts, shellQuote(u.String()))
+
+	return strings.Join(parts, " "), nil
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
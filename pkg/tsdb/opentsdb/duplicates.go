@@ -0,0 +1,64 @@
+package opentsdb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/grafana/pkg/components/null"
+)
+
+// mergeDuplicates combines response elements of the same sub query with the
+// same metric and tags, or the same TSUIDs, which overlapping rollup and raw data can produce,
+// according to strategy: first, last, sum or error.
+func mergeDuplicates(responses []OpenTsdbResponse, data OpenTsdbQuery, strategy string) ([]OpenTsdbResponse, error) {
+	switch strategy {
+	case "first", "last", "sum", "error":
+	default:
+		return nil, fmt.Errorf("Invalid duplicateSeries %q: should be first, last, sum or error", strategy)
+	}
+
+	merged := make([]OpenTsdbResponse, 0, len(responses))
+	seen := make(map[string]int, len(responses))
+	for _, response := range responses {
+		key := strconv.Itoa(subQueryIndex(response, data)) + " " + responseKey(response)
+		if response.Percentile != nil {
+			key += " " + strconv.FormatFloat(*response.Percentile, 'f', -1, 64)
+		}
+		i, ok := seen[key]
+		if !ok {
+			seen[key] = len(merged)
+			merged = append(merged, response)
+			continue
+		}
+
+		switch strategy {
+		case "error":
+			return nil, fmt.Errorf("Series %s was returned more than once. Set duplicateSeries to first, last or sum to merge the copies", formatSeriesName(response.Metric, response.Tags))
+		case "last":
+			merged[i] = response
+		case "sum":
+			merged[i].DataPoints = sumDataPoints(merged[i].DataPoints, response.DataPoints)
+		}
+	}
+	return merged, nil
+}
+
+// sumDataPoints adds the values of other to the values of dps with the same
+// timestamp. Null values are skipped, so a timestamp only becomes null when
+// all its values are.
+func sumDataPoints(dps map[string]OpenTsdbValue, other map[string]OpenTsdbValue) map[string]OpenTsdbValue {
+	sum := make(map[string]OpenTsdbValue, len(dps))
+	for timestamp, value := range dps {
+		sum[timestamp] = value
+	}
+	for timestamp, value := range other {
+		existing, ok := sum[timestamp]
+		switch {
+		case !ok || !existing.Valid:
+			sum[timestamp] = value
+		case value.Valid:
+			sum[timestamp] = OpenTsdbValue{null.FloatFrom(existing.Float64 + value.Float64)}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,87 @@
+package opentsdb
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbDuplicates(t *testing.T) {
+	Convey("OpenTsdb duplicate series testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{JsonData: simplejson.New()}
+		query := &tsdb.Query{Model: simplejson.New()}
+		data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}}}
+		response := `[
+			{"metric": "cpu", "tags": {"host": "a"}, "dps": {"60": 1, "120": null}},
+			{"metric": "cpu", "tags": {"host": "b"}, "dps": {"60": 5}},
+			{"metric": "cpu", "tags": {"host": "a"}, "dps": {"60": 2, "120": 3, "180": 4}}
+		]`
+
+		Convey("Should fail on duplicated series by default", func() {
+			_, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cpu{host=a}")
+		})
+
+		Convey("Should keep the first or last copy", func() {
+			query.Model.Set("duplicateSeries", "first")
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(len(queryRes.Series), ShouldEqual, 2)
+			So(len(queryRes.Series[0].Points), ShouldEqual, 2)
+			So(queryRes.Series[0].Points[0][0].Float64, ShouldEqual, 1)
+
+			query.Model.Set("duplicateSeries", "last")
+			queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(len(queryRes.Series), ShouldEqual, 2)
+			So(len(queryRes.Series[0].Points), ShouldEqual, 3)
+			So(queryRes.Series[0].Points[0][0].Float64, ShouldEqual, 2)
+		})
+
+		Convey("Should sum the copies", func() {
+			query.Model.Set("duplicateSeries", "sum")
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(len(queryRes.Series), ShouldEqual, 2)
+			points := queryRes.Series[0].Points
+			So(len(points), ShouldEqual, 3)
+			So(points[0][0].Float64, ShouldEqual, 3)
+			So(points[1][0].Float64, ShouldEqual, 3)
+			So(points[2][0].Float64, ShouldEqual, 4)
+		})
+
+		Convey("Should not merge series of different sub queries", func() {
+			index := 1
+			responses := []OpenTsdbResponse{
+				{Metric: "cpu", Query: &OpenTsdbSubQuery{}},
+				{Metric: "cpu", Query: &OpenTsdbSubQuery{Index: &index}},
+			}
+			data.Queries = append(data.Queries, map[string]interface{}{"metric": "cpu", "aggregator": "max"})
+
+			merged, err := mergeDuplicates(responses, data, "error")
+			So(err, ShouldBeNil)
+			So(len(merged), ShouldEqual, 2)
+		})
+
+		Convey("Should detect copies by their TSUIDs", func() {
+			response := `[
+				{"metric": "cpu", "tags": {"host": "a"}, "tsuids": ["0001"], "dps": {"60": 1}},
+				{"metric": "cpu", "tags": {"host": "renamed"}, "tsuids": ["0001"], "dps": {"60": 2}}
+			]`
+
+			_, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should reject unknown strategies", func() {
+			_, err := mergeDuplicates(nil, data, "avg")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
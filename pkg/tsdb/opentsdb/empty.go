@@ -0,0 +1,67 @@
+package opentsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// Reasons explainEmpty gives for empty results.
+const (
+	emptyNoData         = "noData"
+	emptyMetricNotFound = "metricNotFound"
+)
+
+// unknownMetricMessage starts the error message OpenTSDB fails queries of
+// unknown metrics with, e.g. No such name for 'metrics': 'sys.cpu'.
+const unknownMetricMessage = "No such name for 'metrics'"
+
+// isUnknownMetricError reports whether err is OpenTSDB failing a query
+// because one of its metrics doesn't exist.
+func isUnknownMetricError(err error) bool {
+	var requestErr *requestError
+	return errors.As(err, &requestErr) && requestErr.Detail != nil && strings.HasPrefix(requestErr.Detail.Message, unknownMetricMessage)
+}
+
+// unknownMetricResult returns an empty result in place of err when err is
+// OpenTSDB failing the query for an unknown metric, so that explainEmpty can
+// say so. Other errors are returned as they are.
+func unknownMetricResult(queryRes *tsdb.QueryResult, err error) (*tsdb.QueryResult, error) {
+	if err == nil || !isUnknownMetricError(err) {
+		return queryRes, err
+	}
+	queryRes = tsdb.NewQueryResult()
+	queryRes.Meta = simplejson.New()
+	return queryRes, nil
+}
+
+// explainEmptyResult tells whether the metrics of data exist, by asking
+// suggest for each, and records in the meta and warnings of queryRes why it
+// has no series.
+func (e *OpenTsdbExecutor) explainEmptyResult(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, data OpenTsdbQuery, queryRes *tsdb.QueryResult) error {
+	var missing []string
+	for _, metric := range queryMetrics(data) {
+		names, err := e.suggestMetrics(ctx, dsInfo, httpClient, headers, metric, dsInfo.JsonData.Get("lookupLimit").MustInt(1000))
+		if err != nil {
+			return err
+		}
+		if !containsString(names, metric) {
+			missing = append(missing, metric)
+		}
+	}
+
+	if len(missing) > 0 {
+		queryRes.Meta.Set("emptyReason", emptyMetricNotFound)
+		addWarning(queryRes, fmt.Sprintf("Metric not found: %s. Check the metric name for typos", strings.Join(missing, ", ")))
+		return nil
+	}
+	queryRes.Meta.Set("emptyReason", emptyNoData)
+	addWarning(queryRes, "The metric exists but has no data in the time range")
+	return nil
+}
@@ -0,0 +1,94 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbEmptyResults(t *testing.T) {
+	Convey("OpenTsdb empty result testing", t, func() {
+
+		suggests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/suggest" {
+				suggests++
+				fmt.Fprint(w, `["sys.cpu", "sys.cpu.user"]`)
+				return
+			}
+
+			var data OpenTsdbQuery
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if metric := data.Queries[0]["metric"]; metric != "sys.cpu" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": {"code": 400, "message": "No such name for 'metrics': '%s'"}}`, metric)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		}))
+		defer server.Close()
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{
+			Url:      server.URL,
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+		query.Model.Set("metric", "sys.cpu")
+		query.Model.Set("aggregator", "sum")
+		queryContext := &tsdb.TsdbQuery{
+			TimeRange: tsdb.NewTimeRange("1h", "now"),
+			Queries:   []*tsdb.Query{query},
+		}
+
+		Convey("Should not explain empty results by default", func() {
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			_, ok := resp.Results["A"].Meta.CheckGet("emptyReason")
+			So(ok, ShouldBeFalse)
+			So(suggests, ShouldEqual, 0)
+
+			query.Model.Set("metric", "sys.cpu.typo")
+			_, err = exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should tell metrics without data in the range", func() {
+			query.Model.Set("explainEmpty", true)
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(resp.Results["A"].Meta.Get("emptyReason").MustString(), ShouldEqual, emptyNoData)
+			So(suggests, ShouldEqual, 1)
+		})
+
+		Convey("Should tell unknown metrics", func() {
+			query.Model.Set("explainEmpty", true)
+			query.Model.Set("metric", "sys.cpu.typo")
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(resp.Results["A"].Meta.Get("emptyReason").MustString(), ShouldEqual, emptyMetricNotFound)
+			So(resp.Results["A"].Meta.Get("warnings").MustArray(), ShouldResemble, []interface{}{
+				"Metric not found: sys.cpu.typo. Check the metric name for typos",
+			})
+		})
+
+		Convey("Should recognize unknown metric errors", func() {
+			So(isUnknownMetricError(&requestError{Detail: &OpenTsdbError{Message: "No such name for 'metrics': 'x'"}}), ShouldBeTrue)
+			So(isUnknownMetricError(&requestError{Detail: &OpenTsdbError{Message: "No such name for 'tagk': 'x'"}}), ShouldBeFalse)
+			So(isUnknownMetricError(&requestError{}), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,112 @@
+package opentsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// storageBackends are the storage layers OpenTSDB runs on. Errors mentioning
+// them come from the storage layer rather than from the query itself.
+var storageBackends = []string{"hbase", "bigtable", "cassandra"}
+
+// OpenTsdbError is the error description OpenTSDB puts in the body of failed
+// requests.
+type OpenTsdbError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Details string `json:"details"`
+	Trace   string `json:"trace"`
+}
+
+// Levels of the datasource's errorDetail setting, controlling how much of the
+// errors of OpenTSDB is shown to users.
+const (
+	errorDetailOff   = "off"
+	errorDetailBasic = "basic"
+	errorDetailFull  = "full"
+)
+
+// requestError is returned for requests OpenTSDB answered with an error
+// status. Detail holds the error from the response body, when there is one.
+// Level is the errorDetail level the error is formatted with, basic if empty.
+// StatusCode labels authentication failures, when set.
+type requestError struct {
+	Status     string
+	StatusCode int
+	Detail     *OpenTsdbError
+	Level      string
+}
+
+func newRequestError(status string, body []byte) *requestError {
+	var errorBody struct {
+		Error *OpenTsdbError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorBody); err != nil {
+		errorBody.Error = nil
+	}
+
+	return &requestError{Status: status, Detail: errorBody.Error}
+}
+
+func (e *requestError) Error() string {
+	message := fmt.Sprintf("Request failed status: %v", e.Status)
+	// Credentials are the usual suspect, so say so rather than leave users
+	// to decode the status.
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		message = "OpenTSDB rejected credentials (401). Check the authentication settings of the datasource"
+	case http.StatusForbidden:
+		message = "OpenTSDB denied access (403). Check the permissions of the datasource's credentials"
+	}
+	if e.Level == errorDetailOff || e.Detail == nil || e.Detail.Message == "" {
+		return message
+	}
+
+	message += ": " + e.Detail.Message
+	if e.Level == errorDetailFull {
+		if e.Detail.Details != "" {
+			message += ": " + e.Detail.Details
+		}
+		if e.Detail.Trace != "" {
+			message += "\n" + e.Detail.Trace
+		}
+		return message
+	}
+
+	if e.isStorageError() && e.Detail.Details != "" {
+		message += fmt.Sprintf(" (storage error: %s)", e.Detail.Details)
+	}
+	return message
+}
+
+// errorDetail returns the level of the datasource's errorDetail setting.
+// Server internals can be sensitive on shared instances, so unknown levels
+// fall back to basic.
+func errorDetail(dsInfo *models.DataSource) string {
+	switch level := dsInfo.JsonData.Get("errorDetail").MustString(errorDetailBasic); level {
+	case errorDetailOff, errorDetailFull:
+		return level
+	default:
+		return errorDetailBasic
+	}
+}
+
+// isStorageError reports whether the request failed in OpenTSDB's storage
+// layer, which usually is transient, rather than because of the query.
+func (e *requestError) isStorageError() bool {
+	if e.Detail == nil {
+		return false
+	}
+
+	text := strings.ToLower(e.Detail.Message + " " + e.Detail.Details)
+	for _, backend := range storageBackends {
+		if strings.Contains(text, backend) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package opentsdb
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbErrors(t *testing.T) {
+	Convey("OpenTsdb errors testing", t, func() {
+
+		Convey("Keep the status for bodies without error", func() {
+			err := newRequestError("502 Bad Gateway", []byte("<html>Bad Gateway</html>"))
+
+			So(err.Error(), ShouldEqual, "Request failed status: 502 Bad Gateway")
+		})
+
+		Convey("Add the message of query errors", func() {
+			err := newRequestError("400 Bad Request", []byte(`{"error": {
+				"code": 400,
+				"message": "No such name for 'metrics': 'sys.cpu'",
+				"details": "Unable to resolve one or more UIDs",
+				"trace": "net.opentsdb.uid.NoSuchUniqueName ..."
+			}}`))
+
+			So(err.isStorageError(), ShouldBeFalse)
+			So(err.Error(), ShouldEqual, "Request failed status: 400 Bad Request: No such name for 'metrics': 'sys.cpu'")
+		})
+
+		Convey("Add the details of storage exceptions", func() {
+			err := newRequestError("500 Internal Server Error", []byte(`{"error": {
+				"code": 500,
+				"message": "Sorry, but there was an error processing your request",
+				"details": "org.hbase.async.RegionOfflineException: tsdb,,1 is offline",
+				"trace": "org.hbase.async.RegionOfflineException ..."
+			}}`))
+
+			So(err.isStorageError(), ShouldBeTrue)
+			So(err.Error(), ShouldEqual, "Request failed status: 500 Internal Server Error: "+
+				"Sorry, but there was an error processing your request "+
+				"(storage error: org.hbase.async.RegionOfflineException: tsdb,,1 is offline)")
+		})
+
+		Convey("Format errors at the errorDetail level", func() {
+			body := []byte(`{"error": {
+				"code": 400,
+				"message": "No such name for 'metrics': 'sys.cpu'",
+				"details": "Unable to resolve one or more UIDs",
+				"trace": "net.opentsdb.uid.NoSuchUniqueName"
+			}}`)
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			So(errorDetail(dsInfo), ShouldEqual, "basic")
+
+			dsInfo.JsonData.Set("errorDetail", "off")
+			_, err := readResponse(dsInfo, newResponse(400, string(body)))
+			So(err.Error(), ShouldEqual, "Request failed status: Bad Request")
+
+			dsInfo.JsonData.Set("errorDetail", "full")
+			_, err = readResponse(dsInfo, newResponse(400, string(body)))
+			So(err.Error(), ShouldEqual, "Request failed status: Bad Request: No such name for 'metrics': 'sys.cpu': "+
+				"Unable to resolve one or more UIDs\nnet.opentsdb.uid.NoSuchUniqueName")
+
+			dsInfo.JsonData.Set("errorDetail", "everything")
+			So(errorDetail(dsInfo), ShouldEqual, "basic")
+		})
+
+		Convey("Label authentication failures", func() {
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+
+			_, err := readResponse(dsInfo, newResponse(401, `{"error": {"code": 401, "message": "Unauthorized"}}`))
+			So(err.Error(), ShouldEqual, "OpenTSDB rejected credentials (401). Check the authentication settings of the datasource: Unauthorized")
+
+			_, err = readResponse(dsInfo, newResponse(403, "<html>Forbidden</html>"))
+			So(err.Error(), ShouldEqual, "OpenTSDB denied access (403). Check the permissions of the datasource's credentials")
+		})
+	})
+}
@@ -0,0 +1,192 @@
+package opentsdb
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// defaultMaxFillBuckets bounds the buckets filled per series of datasources
+// without a maxBuckets limit.
+const defaultMaxFillBuckets = 100000
+
+var intervalPattern = regexp.MustCompile(`^(\d+)(ms|s|m|h|d|w)$`)
+
+var intervalUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// parseInterval parses a fixed width OpenTSDB interval such as "5m" or
+// "500ms". Calendar intervals like "1n" have no fixed width and are rejected.
+func parseInterval(interval string) (time.Duration, error) {
+	match := intervalPattern.FindStringSubmatch(interval)
+	if match == nil {
+		return 0, fmt.Errorf("Invalid interval %q", interval)
+	}
+
+	value, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("Invalid interval %q", interval)
+	}
+
+	return time.Duration(value) * intervalUnits[match[2]], nil
+}
+
+// downsampleInterval returns the bucket width of a downsampled sub query.
+func downsampleInterval(metric map[string]interface{}) (time.Duration, error) {
+	downsample, ok := metric["downsample"].(string)
+	if !ok {
+		return 0, fmt.Errorf("Filling points requires downsampling to be enabled")
+	}
+
+	return parseInterval(strings.SplitN(downsample, "-", 2)[0])
+}
+
+// maxFillBuckets returns the number of buckets series may be filled up to,
+// the datasource's maxBuckets when it's set.
+func maxFillBuckets(dsInfo *models.DataSource) int64 {
+	if maxBuckets := dsInfo.JsonData.Get("maxBuckets").MustInt64(); maxBuckets > 0 {
+		return maxBuckets
+	}
+	return defaultMaxFillBuckets
+}
+
+// fillNulls inserts a null point at every bucket of the interval grid
+// between start and end (in milliseconds) that has no point. Points must be
+// sorted by timestamp.
+func fillNulls(points tsdb.TimeSeriesPoints, start int64, end int64, interval time.Duration, maxBuckets int64) (tsdb.TimeSeriesPoints, error) {
+	return fillGrid(points, start, end, interval, maxBuckets, func(filled tsdb.TimeSeriesPoints, timestamp float64) (tsdb.TimePoint, bool) {
+		return tsdb.NewTimePoint(null.FloatFromPtr(nil), timestamp), true
+	})
+}
+
+// fillCarryForward fills every bucket of the interval grid between start and
+// end (in milliseconds) that has no point with the value of the previous
+// point. Buckets before the first point are left empty. Points must be
+// sorted by timestamp.
+func fillCarryForward(points tsdb.TimeSeriesPoints, start int64, end int64, interval time.Duration, maxBuckets int64) (tsdb.TimeSeriesPoints, error) {
+	return fillGrid(points, start, end, interval, maxBuckets, func(filled tsdb.TimeSeriesPoints, timestamp float64) (tsdb.TimePoint, bool) {
+		if len(filled) == 0 {
+			return tsdb.TimePoint{}, false
+		}
+		return tsdb.NewTimePoint(filled[len(filled)-1][0], timestamp), true
+	})
+}
+
+// fillGrid walks the buckets of the interval grid between start and end and
+// asks missing for a point for every bucket lacking one. Grids of more than
+// maxBuckets buckets, such as 1ms over a long range, are refused rather than
+// filled.
+func fillGrid(points tsdb.TimeSeriesPoints, start int64, end int64, interval time.Duration, maxBuckets int64, missing func(filled tsdb.TimeSeriesPoints, timestamp float64) (tsdb.TimePoint, bool)) (tsdb.TimeSeriesPoints, error) {
+	step := int64(interval / time.Millisecond)
+	if step <= 0 {
+		return points, nil
+	}
+
+	first := start - start%step
+	if end >= first {
+		if buckets := (end-first)/step + 1; buckets > maxBuckets {
+			return nil, fmt.Errorf("Filling %d buckets of %s is more than the limit of %d. Use a coarser downsample interval or a shorter time range",
+				buckets, formatInterval(interval), maxBuckets)
+		}
+	}
+
+	filled := make(tsdb.TimeSeriesPoints, 0, len(points))
+	i := 0
+	for bucket := first; bucket <= end; bucket += step {
+		timestamp := float64(bucket)
+		for i < len(points) && points[i][1].Float64 < timestamp {
+			filled = append(filled, points[i])
+			i++
+		}
+		if i < len(points) && points[i][1].Float64 == timestamp {
+			filled = append(filled, points[i])
+			i++
+			continue
+		}
+		if point, ok := missing(filled, timestamp); ok {
+			filled = append(filled, point)
+		}
+	}
+
+	return append(filled, points[i:]...), nil
+}
+
+// alignPoints moves every point to the start of its bucket of the interval
+// grid, keeping the last point of buckets with several. Points must be
+// sorted by timestamp.
+func alignPoints(points tsdb.TimeSeriesPoints, interval time.Duration) tsdb.TimeSeriesPoints {
+	step := float64(interval / time.Millisecond)
+	if step <= 0 {
+		return points
+	}
+
+	aligned := make(tsdb.TimeSeriesPoints, 0, len(points))
+	for _, point := range points {
+		timestamp := point[1].Float64
+		point[1] = null.FloatFrom(timestamp - math.Mod(timestamp, step))
+		if n := len(aligned); n > 0 && aligned[n-1][1].Float64 == point[1].Float64 {
+			aligned[n-1] = point
+			continue
+		}
+		aligned = append(aligned, point)
+	}
+	return aligned
+}
+
+// allNulls reports whether points holds no valid value, which includes
+// series without any point.
+func allNulls(points tsdb.TimeSeriesPoints) bool {
+	for _, point := range points {
+		if point[0].Valid {
+			return false
+		}
+	}
+	return true
+}
+
+// addBoundaryNulls adds null points at start and end (in milliseconds) unless
+// points already has points there, so that graphs reach the edges of the time
+// range. Points must be sorted by timestamp.
+func addBoundaryNulls(points tsdb.TimeSeriesPoints, start int64, end int64) tsdb.TimeSeriesPoints {
+	if len(points) == 0 || points[0][1].Float64 > float64(start) {
+		points = append(tsdb.TimeSeriesPoints{tsdb.NewTimePoint(null.FloatFromPtr(nil), float64(start))}, points...)
+	}
+	if points[len(points)-1][1].Float64 < float64(end) {
+		points = append(points, tsdb.NewTimePoint(null.FloatFromPtr(nil), float64(end)))
+	}
+	return points
+}
+
+// validPointRange returns the timestamps of the first and the last point of
+// points holding a value. Points must be sorted by timestamp.
+func validPointRange(points tsdb.TimeSeriesPoints) (float64, float64, bool) {
+	first := -1
+	for i, point := range points {
+		if point[0].Valid {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return 0, 0, false
+	}
+
+	last := len(points) - 1
+	for !points[last][0].Valid {
+		last--
+	}
+	return points[first][1].Float64, points[last][1].Float64, true
+}
@@ -0,0 +1,165 @@
+package opentsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFill(t *testing.T) {
+	Convey("OpenTsdb fill testing", t, func() {
+
+		Convey("Parse intervals", func() {
+			interval, err := parseInterval("5m")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, 5*time.Minute)
+
+			interval, err = parseInterval("500ms")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, 500*time.Millisecond)
+
+			interval, err = parseInterval("1w")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, 7*24*time.Hour)
+
+			_, err = parseInterval("1n")
+			So(err, ShouldNotBeNil)
+
+			_, err = parseInterval("0m")
+			So(err, ShouldNotBeNil)
+
+			_, err = parseInterval("$__interval")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Read the downsample interval of a sub query", func() {
+			interval, err := downsampleInterval(map[string]interface{}{"downsample": "10s-avg-nan"})
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, 10*time.Second)
+
+			_, err = downsampleInterval(map[string]interface{}{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Fill missing points with nulls", func() {
+			points := tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(1), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(3), 180000),
+			}
+
+			filled, err := fillNulls(points, 30000, 240000, time.Minute, defaultMaxFillBuckets)
+			So(err, ShouldBeNil)
+
+			So(len(filled), ShouldEqual, 5)
+			So(filled[0][1].Float64, ShouldEqual, 0)
+			So(filled[0][0].Valid, ShouldBeFalse)
+			So(filled[1][0].Float64, ShouldEqual, 1)
+			So(filled[2][1].Float64, ShouldEqual, 120000)
+			So(filled[2][0].Valid, ShouldBeFalse)
+			So(filled[3][0].Float64, ShouldEqual, 3)
+			So(filled[4][1].Float64, ShouldEqual, 240000)
+			So(filled[4][0].Valid, ShouldBeFalse)
+		})
+
+		Convey("Carry the last value forward", func() {
+			points := tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(1), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(4), 240000),
+			}
+
+			filled, err := fillCarryForward(points, 0, 300000, time.Minute, defaultMaxFillBuckets)
+			So(err, ShouldBeNil)
+
+			So(len(filled), ShouldEqual, 5)
+			So(filled[0][1].Float64, ShouldEqual, 60000)
+			So(filled[1][0].Float64, ShouldEqual, 1)
+			So(filled[1][1].Float64, ShouldEqual, 120000)
+			So(filled[2][0].Float64, ShouldEqual, 1)
+			So(filled[2][1].Float64, ShouldEqual, 180000)
+			So(filled[3][0].Float64, ShouldEqual, 4)
+			So(filled[4][0].Float64, ShouldEqual, 4)
+			So(filled[4][1].Float64, ShouldEqual, 300000)
+		})
+
+		Convey("Refuse to fill more buckets than the limit", func() {
+			points := tsdb.TimeSeriesPoints{tsdb.NewTimePoint(null.FloatFrom(1), 60000)}
+
+			_, err := fillNulls(points, 0, 24*60*60*1000, time.Millisecond, defaultMaxFillBuckets)
+			So(err, ShouldResemble, errors.New("Filling 86400001 buckets of 1ms is more than the limit of 100000. Use a coarser downsample interval or a shorter time range"))
+			_, err = fillCarryForward(points, 0, 300000, time.Minute, 5)
+			So(err, ShouldNotBeNil)
+			_, err = fillCarryForward(points, 0, 300000, time.Minute, 6)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Use the maxBuckets limit of the datasource", func() {
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			So(maxFillBuckets(dsInfo), ShouldEqual, defaultMaxFillBuckets)
+			dsInfo.JsonData.Set("maxBuckets", 500)
+			So(maxFillBuckets(dsInfo), ShouldEqual, 500)
+		})
+
+		Convey("Align points to the interval grid", func() {
+			points := tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(1), 60500),
+				tsdb.NewTimePoint(null.FloatFrom(2), 119000),
+				tsdb.NewTimePoint(null.FloatFrom(3), 130000),
+			}
+
+			aligned := alignPoints(points, time.Minute)
+			So(aligned, ShouldResemble, tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(2), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(3), 120000),
+			})
+		})
+
+		Convey("Add null points at the range boundaries", func() {
+			points := tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFrom(1), 120000),
+				tsdb.NewTimePoint(null.FloatFrom(2), 180000),
+			}
+
+			So(addBoundaryNulls(points, 60000, 240000), ShouldResemble, tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(1), 120000),
+				tsdb.NewTimePoint(null.FloatFrom(2), 180000),
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 240000),
+			})
+			So(addBoundaryNulls(points, 120000, 180000), ShouldResemble, points)
+			So(addBoundaryNulls(nil, 60000, 240000), ShouldResemble, tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 60000),
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 240000),
+			})
+		})
+
+		Convey("Find the first and last points with values", func() {
+			first, last, ok := validPointRange(tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(1), 120000),
+				tsdb.NewTimePoint(null.FloatFrom(2), 180000),
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 240000),
+			})
+			So(ok, ShouldBeTrue)
+			So(first, ShouldEqual, 120000)
+			So(last, ShouldEqual, 180000)
+
+			_, _, ok = validPointRange(tsdb.TimeSeriesPoints{tsdb.NewTimePoint(null.FloatFromPtr(nil), 60000)})
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Detect series without values", func() {
+			So(allNulls(nil), ShouldBeTrue)
+			So(allNulls(tsdb.TimeSeriesPoints{tsdb.NewTimePoint(null.FloatFromPtr(nil), 60000)}), ShouldBeTrue)
+			So(allNulls(tsdb.TimeSeriesPoints{
+				tsdb.NewTimePoint(null.FloatFromPtr(nil), 60000),
+				tsdb.NewTimePoint(null.FloatFrom(0), 120000),
+			}), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package opentsdb
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// seriesToFrames converts series to data frames, one per series with a time
+// field and a value field named after the series and labeled with its tags.
+// Value fields of series with an entry in units, keyed by seriesKey, get that
+// unit.
+func seriesToFrames(series tsdb.TimeSeriesSlice, units map[string]string) (data.Frames, error) {
+	frames := make(data.Frames, 0, len(series))
+	for _, s := range series {
+		frame, err := tsdb.SeriesToFrame(s)
+		if err != nil {
+			return nil, err
+		}
+		frame.Fields[1].Name = s.Name
+		if unit, ok := units[seriesKey(s)]; ok {
+			frame.Fields[1].Config = &data.FieldConfig{Unit: unit}
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// encodeFrames replaces the series of queryRes with Arrow encoded data
+// frames, for the dataframe based query path.
+func encodeFrames(queryRes *tsdb.QueryResult) error {
+	var units map[string]string
+	if queryRes.Meta != nil {
+		units, _ = queryRes.Meta.Get("seriesUnits").Interface().(map[string]string)
+	}
+	frames, err := seriesToFrames(queryRes.Series, units)
+	if err != nil {
+		return err
+	}
+
+	encoded := make([][]byte, 0, len(frames))
+	for _, frame := range frames {
+		frameEnc, err := frame.MarshalArrow()
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, frameEnc)
+	}
+
+	queryRes.Dataframes = encoded
+	queryRes.Series = nil
+	return nil
+}
@@ -0,0 +1,81 @@
+package opentsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbFrames(t *testing.T) {
+	Convey("OpenTsdb frames testing", t, func() {
+
+		series := tsdb.TimeSeriesSlice{
+			{
+				Name: "cpu{host=web01}",
+				Tags: map[string]string{"host": "web01"},
+				Points: tsdb.TimeSeriesPoints{
+					tsdb.NewTimePoint(null.FloatFrom(1), 60000),
+					tsdb.NewTimePoint(null.FloatFrom(2), 120000),
+				},
+			},
+			{
+				Name: "cpu{host=web02}",
+				Tags: map[string]string{"host": "web02"},
+				Points: tsdb.TimeSeriesPoints{
+					tsdb.NewTimePoint(null.FloatFrom(3), 60000),
+				},
+			},
+		}
+
+		Convey("Convert series to frames", func() {
+			frames, err := seriesToFrames(series, nil)
+			So(err, ShouldBeNil)
+			So(len(frames), ShouldEqual, 2)
+
+			frame := frames[0]
+			So(frame.Name, ShouldEqual, "cpu{host=web01}")
+			So(len(frame.Fields), ShouldEqual, 2)
+			So(frame.Fields[0].Type(), ShouldEqual, data.FieldTypeNullableTime)
+			So(*frame.Fields[0].At(1).(*time.Time), ShouldResemble, time.Unix(120, 0))
+			So(frame.Fields[1].Type(), ShouldEqual, data.FieldTypeNullableFloat64)
+			So(frame.Fields[1].Labels, ShouldResemble, data.Labels{"host": "web01"})
+			So(frame.Fields[1].Config, ShouldBeNil)
+		})
+
+		Convey("Set the units of value fields", func() {
+			frames, err := seriesToFrames(series, map[string]string{seriesKey(series[1]): "percent"})
+			So(err, ShouldBeNil)
+			So(frames[0].Fields[1].Config, ShouldBeNil)
+			So(frames[1].Fields[1].Config.Unit, ShouldEqual, "percent")
+		})
+
+		Convey("Round trip series through frames", func() {
+			frames, err := seriesToFrames(series, nil)
+			So(err, ShouldBeNil)
+
+			for i, frame := range frames {
+				roundTrip, err := tsdb.FrameToSeriesSlice(frame)
+				So(err, ShouldBeNil)
+				So(roundTrip, ShouldResemble, tsdb.TimeSeriesSlice{series[i]})
+			}
+		})
+
+		Convey("Encode the frames of a query result", func() {
+			queryRes := tsdb.NewQueryResult()
+			queryRes.Series = series
+
+			So(encodeFrames(queryRes), ShouldBeNil)
+			So(queryRes.Series, ShouldBeNil)
+			So(len(queryRes.Dataframes), ShouldEqual, 2)
+
+			frame, err := data.UnmarshalArrowFrame(queryRes.Dataframes[1])
+			So(err, ShouldBeNil)
+			So(frame.Name, ShouldEqual, "cpu{host=web02}")
+			So(*frame.Fields[1].At(0).(*float64), ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,54 @@
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana/pkg/tsdb"
+	"golang.org/x/net/http/httpguts"
+)
+
+// reservedHeaders can't be set by queries, as they are owned by the request
+// builders or carry the datasource's credentials.
+var reservedHeaders = []string{"Authorization", "Content-Length", "Content-Type", "Host", "Transfer-Encoding"}
+
+// queryHeaders returns the headers a query's httpHeaders option adds to its
+// requests, e.g. to route them by tenant.
+func queryHeaders(query *tsdb.Query) (http.Header, error) {
+	value, ok := query.Model.CheckGet("httpHeaders")
+	if !ok {
+		return nil, nil
+	}
+
+	values, err := value.Map()
+	if err != nil {
+		return nil, errors.New("httpHeaders should map header names to values")
+	}
+
+	headers := make(http.Header, len(values))
+	for name, v := range values {
+		s, ok := v.(string)
+		if !ok || !httpguts.ValidHeaderFieldValue(s) {
+			return nil, fmt.Errorf("Invalid value of header %q: should be a string", name)
+		}
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("Invalid header name %q", name)
+		}
+		for _, reserved := range reservedHeaders {
+			if http.CanonicalHeaderKey(name) == reserved {
+				return nil, fmt.Errorf("Header %q is reserved and can't be set by queries", name)
+			}
+		}
+		headers.Set(name, s)
+	}
+
+	return headers, nil
+}
+
+// applyHeaders adds headers to a request, replacing any values set before.
+func applyHeaders(req *http.Request, headers http.Header) {
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+}
@@ -0,0 +1,101 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbHeaders(t *testing.T) {
+	Convey("OpenTsdb headers testing", t, func() {
+
+		query := &tsdb.Query{Model: simplejson.New()}
+
+		Convey("Should not add headers by default", func() {
+			headers, err := queryHeaders(query)
+			So(err, ShouldBeNil)
+			So(headers, ShouldBeNil)
+		})
+
+		Convey("Should reject reserved headers", func() {
+			query.Model.Set("httpHeaders", map[string]interface{}{"content-type": "text/plain"})
+
+			_, err := queryHeaders(query)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "reserved")
+		})
+
+		Convey("Should reject invalid header names", func() {
+			query.Model.Set("httpHeaders", map[string]interface{}{"X Tenant": "a"})
+
+			_, err := queryHeaders(query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should apply query headers to data requests", func() {
+			var tenants []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenants = append(tenants, r.Header.Get("X-Tenant"))
+				fmt.Fprint(w, "[]")
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query.Model.Set("httpHeaders", map[string]interface{}{"X-Tenant": "team-a"})
+
+			exec := &OpenTsdbExecutor{}
+			_, err := exec.queryRequest(context.Background(), dsInfo, server.Client(), query, OpenTsdbQuery{
+				Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}},
+			})
+			So(err, ShouldBeNil)
+			So(tenants, ShouldResemble, []string{"team-a"})
+		})
+
+		Convey("Should apply query headers to lookup requests", func() {
+			tenants := make(map[string][]string)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenants[r.URL.Path] = append(tenants[r.URL.Path], r.Header.Get("X-Tenant"))
+				if r.URL.Path == "/api/search/lookup" {
+					fmt.Fprint(w, `{"results": [{"metric": "cpu", "tags": {"host": "web-1"}}], "totalResults": 1}`)
+					return
+				}
+				fmt.Fprint(w, "[]")
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.NewFromAny(map[string]interface{}{"maxQueryCost": 1000000}),
+			}
+			exec := &OpenTsdbExecutor{}
+			run := func(model map[string]interface{}) {
+				query := &tsdb.Query{RefId: "A", Model: simplejson.NewFromAny(model)}
+				query.Model.Set("httpHeaders", map[string]interface{}{"X-Tenant": "team-a"})
+				_, err := exec.Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+					TimeRange: tsdb.NewTimeRange("1h", "now"),
+					Queries:   []*tsdb.Query{query},
+				})
+				So(err, ShouldBeNil)
+			}
+
+			run(map[string]interface{}{"type": "metricFindQuery", "subtype": "tag_keys", "metric": "cpu"})
+			run(map[string]interface{}{"metric": "cpu", "aggregator": "sum", "existsOnly": true})
+			run(map[string]interface{}{"metric": "cpu", "aggregator": "sum"})
+
+			So(tenants, ShouldResemble, map[string][]string{
+				"/api/search/lookup": {"team-a", "team-a", "team-a"},
+				"/api/query":         {"team-a"},
+			})
+		})
+	})
+}
@@ -0,0 +1,44 @@
+package opentsdb
+
+import "fmt"
+
+// interpolatedAggregators maps the interpolation option to the aggregator
+// variant implementing it, for each aggregator that has one. Aggregators
+// interpolate linearly unless they are one of these variants.
+var interpolatedAggregators = map[string]map[string]string{
+	// Zero if missing: missing points count as zero.
+	"zim": {"sum": "zimsum"},
+	// Max or min if missing: missing points can't change the result.
+	"mim": {"min": "mimmin", "max": "mimmax"},
+}
+
+// interpolationAggregator returns the variant of aggregator that implements
+// interpolation, which is lerp, zim, mim or empty to keep the aggregator as
+// it is.
+func interpolationAggregator(aggregator string, interpolation string) (string, error) {
+	if interpolation == "" {
+		return aggregator, nil
+	}
+
+	if interpolation == "lerp" {
+		for policy, variants := range interpolatedAggregators {
+			for _, variant := range variants {
+				if aggregator == variant {
+					return "", fmt.Errorf("Aggregator %s uses %s interpolation, not lerp", aggregator, policy)
+				}
+			}
+		}
+		return aggregator, nil
+	}
+
+	variants, ok := interpolatedAggregators[interpolation]
+	if !ok {
+		return "", fmt.Errorf("Invalid interpolation %q: should be lerp, zim or mim", interpolation)
+	}
+	for base, variant := range variants {
+		if aggregator == base || aggregator == variant {
+			return variant, nil
+		}
+	}
+	return "", fmt.Errorf("Interpolation %s isn't available for aggregator %s", interpolation, aggregator)
+}
@@ -0,0 +1,71 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbInterpolation(t *testing.T) {
+	Convey("OpenTsdb interpolation testing", t, func() {
+
+		Convey("Keep aggregators without interpolation", func() {
+			aggregator, err := interpolationAggregator("zimsum", "")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "zimsum")
+		})
+
+		Convey("Map interpolations to aggregator variants", func() {
+			aggregator, err := interpolationAggregator("sum", "zim")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "zimsum")
+
+			aggregator, err = interpolationAggregator("max", "mim")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "mimmax")
+
+			aggregator, err = interpolationAggregator("mimmin", "mim")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "mimmin")
+
+			aggregator, err = interpolationAggregator("avg", "lerp")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "avg")
+		})
+
+		Convey("Reject invalid combinations", func() {
+			_, err := interpolationAggregator("avg", "zim")
+			So(err, ShouldNotBeNil)
+
+			_, err = interpolationAggregator("zimsum", "lerp")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Aggregator zimsum uses zim interpolation, not lerp")
+
+			_, err = interpolationAggregator("sum", "cubic")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build metric with interpolation", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("disableDownsampling", true)
+			query.Model.Set("interpolation", "zim")
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["aggregator"], ShouldEqual, "zimsum")
+		})
+	})
+}
@@ -0,0 +1,191 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// executeMetricFindQuery answers the lookups used by template variables and
+// the query editor. The kind of lookup is selected by the query's subtype.
+func (e *OpenTsdbExecutor) executeMetricFindQuery(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, queryContext *tsdb.TsdbQuery) (*tsdb.Response, error) {
+	result := &tsdb.Response{
+		Results: make(map[string]*tsdb.QueryResult),
+	}
+	firstQuery := queryContext.Queries[0]
+	queryResult := &tsdb.QueryResult{Meta: simplejson.New(), RefId: firstQuery.RefId}
+
+	headers, err := queryHeaders(firstQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	var truncated bool
+	subType := firstQuery.Model.Get("subtype").MustString()
+	switch subType {
+	case "tag_keys":
+		values, truncated, err = e.tagKeys(ctx, dsInfo, httpClient, headers, firstQuery.Model.Get("metric").MustString())
+	default:
+		err = fmt.Errorf("Unsupported metric find query subtype %q", subType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transformToTable(values, queryResult)
+	if truncated {
+		queryResult.Meta.Set("truncated", true)
+	}
+	result.Results[firstQuery.RefId] = queryResult
+	return result, nil
+}
+
+// tagKeys returns the sorted, distinct tag keys of the time series of metric,
+// and whether the lookup of the time series was truncated.
+func (e *OpenTsdbExecutor) tagKeys(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, metric string) ([]string, bool, error) {
+	if metric == "" {
+		return nil, false, fmt.Errorf("Looking up tag keys requires a metric")
+	}
+
+	results, truncated, err := e.lookup(ctx, dsInfo, httpClient, headers, metric)
+	if err != nil {
+		return nil, false, err
+	}
+
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, series := range results {
+		for key := range series.Tags {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, truncated, nil
+}
+
+// seriesExists reports whether any time series matches the metric and tags
+// of query. A single lookup result answers that without scanning data.
+func (e *OpenTsdbExecutor) seriesExists(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, query *tsdb.Query) (bool, error) {
+	metric := query.Model.Get("metric").MustString()
+	if metric == "" {
+		return false, fmt.Errorf("Checking series existence requires a metric")
+	}
+
+	page, err := e.lookupPage(ctx, dsInfo, httpClient, headers, lookupTerm(metric, query.Model.Get("tags").MustMap()), 0, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(page.Results) > 0 || page.TotalResults > 0, nil
+}
+
+// lookupTerm builds the lookup query of metric and tags, such as
+// sys.cpu{dc=eu,host=web01}.
+func lookupTerm(metric string, tags map[string]interface{}) string {
+	if len(tags) == 0 {
+		return metric
+	}
+
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		values[key] = fmt.Sprint(value)
+	}
+	return metric + "{" + strings.Join(tagPairs(values), ",") + "}"
+}
+
+// lookup lists the time series of metric using the search lookup endpoint.
+// It pages through the results until they are exhausted or the
+// lookupMaxResults cap is hit, in which case truncated is set.
+func (e *OpenTsdbExecutor) lookup(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, metric string) (results []OpenTsdbLookupResult, truncated bool, err error) {
+	pageSize := dsInfo.JsonData.Get("lookupLimit").MustInt(1000)
+	maxResults := dsInfo.JsonData.Get("lookupMaxResults").MustInt(10000)
+	if pageSize <= 0 {
+		return nil, false, fmt.Errorf("Invalid lookupLimit %d: should be positive", pageSize)
+	}
+
+	for {
+		page, err := e.lookupPage(ctx, dsInfo, httpClient, headers, metric, len(results), pageSize)
+		if err != nil {
+			return nil, false, err
+		}
+		results = append(results, page.Results...)
+
+		exhausted := len(page.Results) < pageSize || (page.TotalResults > 0 && len(results) >= page.TotalResults)
+		if maxResults > 0 && len(results) >= maxResults {
+			truncated = len(results) > maxResults || !exhausted
+			if truncated {
+				results = results[:maxResults]
+			}
+			return results, truncated, nil
+		}
+		if exhausted {
+			return results, false, nil
+		}
+	}
+}
+
+// lookupPage requests a single page of the time series of metric.
+func (e *OpenTsdbExecutor) lookupPage(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, metric string, startIndex int, limit int) (*OpenTsdbLookupResponse, error) {
+	u, err := apiURL(dsInfo, "search/lookup")
+	if err != nil {
+		return nil, err
+	}
+
+	params := u.Query()
+	params.Set("m", metric)
+	params.Set("limit", strconv.Itoa(limit))
+	if startIndex > 0 {
+		params.Set("startIndex", strconv.Itoa(startIndex))
+	}
+	u.RawQuery = params.Encode()
+
+	req, err := newRequest(dsInfo, http.MethodGet, u.String(), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := doRequest(ctx, dsInfo, httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := readResponse(dsInfo, res)
+	if err != nil {
+		return nil, err
+	}
+
+	var lookup OpenTsdbLookupResponse
+	if err := json.Unmarshal(body, &lookup); err != nil {
+		plog.Info("Failed to unmarshal opentsdb lookup response", "error", err, "status", res.Status, "body", string(body))
+		return nil, err
+	}
+
+	return &lookup, nil
+}
+
+func transformToTable(values []string, result *tsdb.QueryResult) {
+	table := &tsdb.Table{
+		Columns: make([]tsdb.TableColumn, 2),
+		Rows:    make([]tsdb.RowValues, 0),
+	}
+	table.Columns[0].Text = "text"
+	table.Columns[1].Text = "value"
+
+	for _, value := range values {
+		table.Rows = append(table.Rows, tsdb.RowValues{value, value})
+	}
+	result.Tables = append(result.Tables, table)
+	result.Meta.Set("rowCount", len(values))
+}
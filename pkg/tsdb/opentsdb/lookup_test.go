@@ -0,0 +1,129 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbLookup(t *testing.T) {
+	Convey("OpenTsdb lookup testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		var requestURL string
+		response := `{"results": [
+			{"tsuid": "0001", "metric": "sys.cpu", "tags": {"host": "web01", "dc": "eu"}},
+			{"tsuid": "0002", "metric": "sys.cpu", "tags": {"host": "web02", "cpu": "0"}}
+		]}`
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURL = r.URL.String()
+			fmt.Fprint(w, response)
+		}))
+		defer server.Close()
+
+		dsInfo := &models.DataSource{
+			Url:      server.URL,
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+		query.Model.Set("type", "metricFindQuery")
+		query.Model.Set("subtype", "tag_keys")
+		query.Model.Set("metric", "sys.cpu")
+		queryContext := &tsdb.TsdbQuery{
+			TimeRange: tsdb.NewTimeRange("1h", "now"),
+			Queries:   []*tsdb.Query{query},
+		}
+
+		Convey("Should return sorted distinct tag keys", func() {
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(requestURL, ShouldEqual, "/api/search/lookup?limit=1000&m=sys.cpu")
+
+			table := resp.Results["A"].Tables[0]
+			So(len(table.Rows), ShouldEqual, 3)
+			So(table.Rows[0][0], ShouldEqual, "cpu")
+			So(table.Rows[1][0], ShouldEqual, "dc")
+			So(table.Rows[2][0], ShouldEqual, "host")
+			So(resp.Results["A"].Meta.Get("rowCount").MustInt(), ShouldEqual, 3)
+		})
+
+		Convey("Should handle metrics without series", func() {
+			response = `{"results": []}`
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(len(resp.Results["A"].Tables[0].Rows), ShouldEqual, 0)
+		})
+
+		Convey("Should page through large lookups", func() {
+			var startIndexes []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				startIndex := r.URL.Query().Get("startIndex")
+				startIndexes = append(startIndexes, startIndex)
+				switch startIndex {
+				case "":
+					fmt.Fprint(w, `{"totalResults": 3, "results": [{"tags": {"host": "a"}}, {"tags": {"dc": "eu"}}]}`)
+				default:
+					fmt.Fprint(w, `{"totalResults": 3, "results": [{"tags": {"cpu": "0"}}]}`)
+				}
+			}))
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.NewFromAny(map[string]interface{}{"lookupLimit": 2}),
+			}
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(startIndexes, ShouldResemble, []string{"", "2"})
+			So(len(resp.Results["A"].Tables[0].Rows), ShouldEqual, 3)
+			_, truncated := resp.Results["A"].Meta.CheckGet("truncated")
+			So(truncated, ShouldBeFalse)
+
+			Convey("Should flag lookups truncated by the cap", func() {
+				startIndexes = nil
+				dsInfo.JsonData.Set("lookupMaxResults", 2)
+
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				So(startIndexes, ShouldResemble, []string{""})
+				So(len(resp.Results["A"].Tables[0].Rows), ShouldEqual, 2)
+				So(resp.Results["A"].Meta.Get("truncated").MustBool(), ShouldBeTrue)
+			})
+		})
+
+		Convey("Should check whether series exist without querying data", func() {
+			existsQuery := &tsdb.Query{RefId: "B", Model: simplejson.New()}
+			existsQuery.Model.Set("metric", "sys.cpu")
+			existsQuery.Model.Set("aggregator", "sum")
+			existsQuery.Model.Set("tags", map[string]interface{}{"host": "web01", "dc": "eu"})
+			existsQuery.Model.Set("existsOnly", true)
+			queryContext.Queries = []*tsdb.Query{existsQuery}
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(requestURL, ShouldEqual, "/api/search/lookup?limit=1&m=sys.cpu%7Bdc%3Deu%2Chost%3Dweb01%7D")
+			So(resp.Results["B"].Meta.Get("exists").MustBool(), ShouldBeTrue)
+			So(len(resp.Results["B"].Series), ShouldEqual, 0)
+
+			response = `{"results": [], "totalResults": 0}`
+			resp, err = exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(resp.Results["B"].Meta.Get("exists").MustBool(), ShouldBeFalse)
+		})
+
+		Convey("Should require a metric", func() {
+			query.Model.Set("metric", "")
+
+			_, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,49 @@
+package opentsdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// intervalFormatUnits are the OpenTSDB interval units, coarsest first.
+var intervalFormatUnits = []string{"w", "d", "h", "m", "s", "ms"}
+
+// replaceIntervalMacros resolves the $__interval and $__interval_ms macros of
+// a downsample interval with the interval Grafana computed for the query.
+func replaceIntervalMacros(query *tsdb.Query, interval string) (string, error) {
+	if !strings.Contains(interval, "$__interval") {
+		return interval, nil
+	}
+
+	ms := query.IntervalMs
+	if ms <= 0 {
+		ms = query.Model.Get("intervalMs").MustInt64()
+	}
+	if ms <= 0 {
+		return "", fmt.Errorf("Invalid downsample interval %q: the query has no interval to resolve it with", interval)
+	}
+
+	resolved := strings.Replace(interval, "$__interval_ms", strconv.FormatInt(ms, 10), -1)
+	// $__interval_ms is a bare number, OpenTSDB needs the unit.
+	if _, err := strconv.ParseInt(resolved, 10, 64); err == nil {
+		resolved += "ms"
+	}
+	resolved = strings.Replace(resolved, "$__interval", formatInterval(time.Duration(ms)*time.Millisecond), -1)
+
+	return resolved, nil
+}
+
+// formatInterval formats d as an OpenTSDB interval in the coarsest unit that
+// represents it exactly.
+func formatInterval(d time.Duration) string {
+	for _, unit := range intervalFormatUnits {
+		if width := intervalUnits[unit]; d%width == 0 {
+			return strconv.FormatInt(int64(d/width), 10) + unit
+		}
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
@@ -0,0 +1,76 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbMacros(t *testing.T) {
+	Convey("OpenTsdb macros testing", t, func() {
+
+		query := &tsdb.Query{
+			Model:      simplejson.New(),
+			IntervalMs: 30000,
+		}
+
+		Convey("Format intervals in their coarsest unit", func() {
+			So(formatInterval(30*time.Second), ShouldEqual, "30s")
+			So(formatInterval(2*time.Minute), ShouldEqual, "2m")
+			So(formatInterval(90*time.Second), ShouldEqual, "90s")
+			So(formatInterval(1500*time.Millisecond), ShouldEqual, "1500ms")
+			So(formatInterval(14*24*time.Hour), ShouldEqual, "2w")
+		})
+
+		Convey("Resolve $__interval", func() {
+			interval, err := replaceIntervalMacros(query, "$__interval")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, "30s")
+		})
+
+		Convey("Resolve $__interval_ms", func() {
+			interval, err := replaceIntervalMacros(query, "$__interval_ms")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, "30000ms")
+
+			interval, err = replaceIntervalMacros(query, "$__interval_msms")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, "30000ms")
+		})
+
+		Convey("Fall back to the interval of the query model", func() {
+			query.IntervalMs = 0
+			query.Model.Set("intervalMs", 60000)
+
+			interval, err := replaceIntervalMacros(query, "$__interval")
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, "1m")
+		})
+
+		Convey("Fail without a query interval", func() {
+			query.IntervalMs = 0
+
+			_, err := replaceIntervalMacros(query, "$__interval")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build metric with an interval macro", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("downsampleInterval", "$__interval")
+			query.Model.Set("downsampleAggregator", "sum")
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["downsample"], ShouldEqual, "30s-sum")
+		})
+	})
+}
@@ -0,0 +1,120 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// expandMetrics replaces the sub queries of metrics whose metric name holds a
+// "*" wildcard with one sub query per metric the wildcard matches, leaving out
+// the metrics matching the query's excludeMetrics patterns. OpenTSDB can't
+// query metric wildcards itself, so the names come from the suggest endpoint.
+func (e *OpenTsdbExecutor) expandMetrics(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, query *tsdb.Query, metrics []map[string]interface{}) ([]map[string]interface{}, error) {
+	var excludes []*regexp.Regexp
+	if value, ok := query.Model.CheckGet("excludeMetrics"); ok {
+		patterns, err := value.StringArray()
+		if err != nil {
+			return nil, errors.New("excludeMetrics should be a list of metric names or patterns")
+		}
+		for _, pattern := range patterns {
+			excludes = append(excludes, metricPattern(pattern))
+		}
+	}
+
+	headers, err := queryHeaders(query)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := make([]map[string]interface{}, 0, len(metrics))
+	for _, subQuery := range metrics {
+		name, _ := subQuery["metric"].(string)
+		if !strings.Contains(name, "*") {
+			expanded = append(expanded, subQuery)
+			continue
+		}
+
+		names, err := e.cachedMetrics(ctx, dsInfo, httpClient, headers, name[:strings.Index(name, "*")])
+		if err != nil {
+			return nil, err
+		}
+
+		pattern := metricPattern(name)
+		matched := 0
+		for _, candidate := range names {
+			if !pattern.MatchString(candidate) || matchesAny(excludes, candidate) {
+				continue
+			}
+
+			metric := copyMetric(subQuery)
+			metric["metric"] = candidate
+			expanded = append(expanded, metric)
+			matched++
+		}
+		if matched == 0 {
+			return nil, fmt.Errorf("No metrics match %q", name)
+		}
+	}
+
+	return expanded, nil
+}
+
+// suggestMetrics lists up to max metric names starting with prefix.
+func (e *OpenTsdbExecutor) suggestMetrics(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, prefix string, max int) ([]string, error) {
+	u, err := apiURL(dsInfo, "suggest")
+	if err != nil {
+		return nil, err
+	}
+
+	params := u.Query()
+	params.Set("type", "metrics")
+	params.Set("q", prefix)
+	params.Set("max", strconv.Itoa(max))
+	u.RawQuery = params.Encode()
+
+	req, err := newRequest(dsInfo, http.MethodGet, u.String(), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := doRequest(ctx, dsInfo, httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := readResponse(dsInfo, res)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err := json.Unmarshal(body, &names); err != nil {
+		plog.Info("Failed to unmarshal opentsdb suggest response", "error", err, "status", res.Status, "body", string(body))
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// metricPattern compiles a metric name where "*" matches any characters.
+func metricPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(name), `\*`, ".*", -1) + "$")
+}
+
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
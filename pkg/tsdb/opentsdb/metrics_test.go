@@ -0,0 +1,71 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbMetrics(t *testing.T) {
+	Convey("OpenTsdb metrics testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		var requestURL string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURL = r.URL.String()
+			fmt.Fprint(w, `["sys.cpu.idle", "sys.cpu.system", "sys.cpu.user", "sys.cpus"]`)
+		}))
+		defer server.Close()
+
+		dsInfo := &models.DataSource{
+			Url:      server.URL,
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{
+			Model: simplejson.New(),
+		}
+		metrics := []map[string]interface{}{{"metric": "sys.cpu.*", "aggregator": "sum"}}
+
+		Convey("Expand metric wildcards", func() {
+			expanded, err := exec.expandMetrics(context.Background(), dsInfo, server.Client(), query, metrics)
+			So(err, ShouldBeNil)
+			So(requestURL, ShouldEqual, "/api/suggest?max=1000&q=sys.cpu.&type=metrics")
+			So(expanded, ShouldResemble, []map[string]interface{}{
+				{"metric": "sys.cpu.idle", "aggregator": "sum"},
+				{"metric": "sys.cpu.system", "aggregator": "sum"},
+				{"metric": "sys.cpu.user", "aggregator": "sum"},
+			})
+		})
+
+		Convey("Exclude metrics from the expansion", func() {
+			query.Model.Set("excludeMetrics", []interface{}{"sys.cpu.idle", "*.system"})
+
+			expanded, err := exec.expandMetrics(context.Background(), dsInfo, server.Client(), query, metrics)
+			So(err, ShouldBeNil)
+			So(expanded, ShouldResemble, []map[string]interface{}{{"metric": "sys.cpu.user", "aggregator": "sum"}})
+		})
+
+		Convey("Reject expansions without metrics", func() {
+			query.Model.Set("excludeMetrics", []interface{}{"sys.cpu.*"})
+
+			_, err := exec.expandMetrics(context.Background(), dsInfo, server.Client(), query, metrics)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Keep metrics without wildcards", func() {
+			metrics := []map[string]interface{}{{"metric": "sys.cpu.user", "aggregator": "sum"}}
+
+			expanded, err := exec.expandMetrics(context.Background(), dsInfo, server.Client(), query, metrics)
+			So(err, ShouldBeNil)
+			So(requestURL, ShouldEqual, "")
+			So(expanded, ShouldResemble, metrics)
+		})
+	})
+}
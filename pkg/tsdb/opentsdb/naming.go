@@ -0,0 +1,68 @@
+package opentsdb
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// tagPairs renders tags as key=value pairs ordered by key, so names built
+// from them don't depend on map iteration order.
+func tagPairs(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return pairs
+}
+
+// tagLabel formats tags the way the query editor labels series, e.g.
+// {host=web-1, region=eu}. Series without tags get no label.
+func tagLabel(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(tagPairs(tags), ", ") + "}"
+}
+
+// formatSeriesName names the series of metric with tags, e.g.
+// cpu{host=web-1, region=eu}, or just cpu without tags. Every option naming
+// series by their tags goes through it, so that names stay uniform.
+func formatSeriesName(metric string, tags map[string]string) string {
+	return metric + tagLabel(tags)
+}
+
+// seriesKey identifies series within a result: its name followed by its
+// tags, such as cpu{host=web-1}. Names alone aren't unique, the series of a
+// metric are all named after it unless tags are added to their names.
+func seriesKey(series *tsdb.TimeSeries) string {
+	return formatSeriesName(series.Name, series.Tags)
+}
+
+// responseKey identifies the series of response. Series OpenTSDB returned
+// TSUIDs for are keyed by them, so that their key survives changes of the
+// tag values they are shown with.
+func responseKey(response OpenTsdbResponse) string {
+	if len(response.TSUIDs) == 0 {
+		return formatSeriesName(response.Metric, response.Tags)
+	}
+
+	tsuids := append([]string(nil), response.TSUIDs...)
+	sort.Strings(tsuids)
+	return "tsuids:" + strings.Join(tsuids, ",")
+}
+
+// sortSeriesByName orders series by name, ignoring case, so that legends
+// don't depend on the order OpenTSDB returned them in.
+func sortSeriesByName(series tsdb.TimeSeriesSlice) {
+	sort.SliceStable(series, func(i, j int) bool {
+		return strings.ToLower(series[i].Name) < strings.ToLower(series[j].Name)
+	})
+}
@@ -0,0 +1,131 @@
+package opentsdb
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbNaming(t *testing.T) {
+	Convey("OpenTsdb naming testing", t, func() {
+
+		Convey("Label tags ordered by key", func() {
+			So(tagLabel(nil), ShouldEqual, "")
+			So(tagLabel(map[string]string{"region": "eu", "host": "web-1"}), ShouldEqual, "{host=web-1, region=eu}")
+		})
+
+		Convey("Format series names", func() {
+			So(formatSeriesName("cpu", nil), ShouldEqual, "cpu")
+			So(formatSeriesName("cpu", map[string]string{}), ShouldEqual, "cpu")
+			So(formatSeriesName("cpu", map[string]string{"host": "web-1"}), ShouldEqual, "cpu{host=web-1}")
+			So(formatSeriesName("cpu", map[string]string{"region": "eu", "host": "web-1", "dc": "a", "env": "prod"}), ShouldEqual,
+				"cpu{dc=a, env=prod, host=web-1, region=eu}")
+		})
+
+		Convey("Key series by their TSUIDs", func() {
+			So(responseKey(OpenTsdbResponse{Metric: "cpu", Tags: map[string]string{"host": "a"}}), ShouldEqual, "cpu{host=a}")
+			So(responseKey(OpenTsdbResponse{Metric: "cpu", TSUIDs: []string{"0002", "0001"}}), ShouldEqual, "tsuids:0001,0002")
+		})
+
+		Convey("Keep series keys across tag value changes", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			query := &tsdb.Query{Model: simplejson.New()}
+			query.Model.Set("tagsInName", true)
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}}}
+
+			first, err := exec.parseResponse(dsInfo, query, data, newResponse(200,
+				`[{"metric": "cpu", "tags": {"host": "web-1", "dc": "eu"}, "tsuids": ["0001", "0002"], "dps": {"60": 1}}]`))
+			So(err, ShouldBeNil)
+			second, err := exec.parseResponse(dsInfo, query, data, newResponse(200,
+				`[{"metric": "cpu", "tags": {"dc": "eu-west", "host": "web-1.example"}, "tsuids": ["0002", "0001"], "dps": {"60": 1}}]`))
+			So(err, ShouldBeNil)
+
+			So(first.Series[0].Name, ShouldNotEqual, second.Series[0].Name)
+			firstKeys := first.Meta.Get("seriesKeys").Interface().(map[string]string)
+			secondKeys := second.Meta.Get("seriesKeys").Interface().(map[string]string)
+			So(firstKeys[seriesKey(first.Series[0])], ShouldEqual, "tsuids:0001,0002")
+			So(secondKeys[seriesKey(second.Series[0])], ShouldEqual, firstKeys[seriesKey(first.Series[0])])
+		})
+
+		Convey("Key series sharing a name apart", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			query := &tsdb.Query{Model: simplejson.New()}
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}}}
+			response := `[
+				{"metric": "cpu", "tags": {"host": "web-1"}, "tsuids": ["0001"], "dps": {"60": 1}},
+				{"metric": "cpu", "tags": {"host": "web-2"}, "tsuids": ["0002"], "dps": {"60": 2}},
+				{"metric": "cpu", "tags": {"host": "web-3"}, "tsuids": ["0003"], "dps": {"60": 3}}
+			]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(queryRes.Series[0].Name, ShouldEqual, queryRes.Series[1].Name)
+			So(queryRes.Meta.Get("seriesKeys").Interface(), ShouldResemble, map[string]string{
+				"cpu{host=web-1}": "tsuids:0001",
+				"cpu{host=web-2}": "tsuids:0002",
+				"cpu{host=web-3}": "tsuids:0003",
+			})
+		})
+
+		Convey("Flatten tags into series names", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			query := &tsdb.Query{Model: simplejson.New()}
+			query.Model.Set("flattenTags", true)
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}}}
+			response := `[{"metric": "cpu", "tags": {"region": "eu", "host": "web-1"}, "dps": {"60": 1}}, {"metric": "cpu", "dps": {"60": 2}}]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(queryRes.Series[0].Name, ShouldEqual, "cpu{host=web-1, region=eu}")
+			So(queryRes.Series[0].Tags, ShouldBeEmpty)
+			So(queryRes.Series[1].Name, ShouldEqual, "cpu")
+		})
+
+		Convey("Name series identically regardless of tag order", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			query := &tsdb.Query{Model: simplejson.New()}
+			query.Model.Set("tagsInName", true)
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}}}
+
+			keys := []string{"host", "region", "dc", "env", "rack"}
+			for i := 0; i < 20; i++ {
+				rand.Shuffle(len(keys), func(a, b int) { keys[a], keys[b] = keys[b], keys[a] })
+				tags := `"` + keys[0] + `": "1"`
+				for _, key := range keys[1:] {
+					tags += `, "` + key + `": "1"`
+				}
+				response := `[{"metric": "cpu", "tags": {` + tags + `}, "dps": {"60": 1}}]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Name, ShouldEqual, "cpu{dc=1, env=1, host=1, rack=1, region=1}")
+			}
+		})
+
+		Convey("Sort series by name ignoring case", func() {
+			names := []string{"b.cpu", "A.mem", "a.disk", "C.net", "c.load"}
+			for i := 0; i < 20; i++ {
+				rand.Shuffle(len(names), func(a, b int) { names[a], names[b] = names[b], names[a] })
+				series := make(tsdb.TimeSeriesSlice, 0, len(names))
+				for _, name := range names {
+					series = append(series, &tsdb.TimeSeries{Name: name})
+				}
+
+				sortSeriesByName(series)
+				sorted := make([]string, 0, len(series))
+				for _, s := range series {
+					sorted = append(sorted, s.Name)
+				}
+				So(sorted, ShouldResemble, []string{"a.disk", "A.mem", "b.cpu", "c.load", "C.net"})
+			}
+		})
+	})
+}
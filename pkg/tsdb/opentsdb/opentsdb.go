@@ -1,13 +1,17 @@
 package opentsdb
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"path"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
-
-	"golang.org/x/net/context/ctxhttp"
+	"time"
 
 	"encoding/json"
 	"io/ioutil"
@@ -15,6 +19,7 @@ import (
 	"net/url"
 
 	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/components/simplejson"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/setting"
@@ -25,62 +30,386 @@ type OpenTsdbExecutor struct {
 }
 
 func NewOpenTsdbExecutor(datasource *models.DataSource) (tsdb.TsdbQueryEndpoint, error) {
-	return &OpenTsdbExecutor{}, nil
+	e := &OpenTsdbExecutor{}
+	e.startPrewarm(datasource)
+	return e, nil
 }
 
 var (
 	plog log.Logger
+
+	errReadOnly = errors.New("datasource is configured read-only.")
 )
 
+// tsdbVersion21 is the default of the datasource's tsdbVersion setting.
+const tsdbVersion21 = 1
+
+// defaultMaxRequestBytes is the default of the maxRequestBytes setting.
+const defaultMaxRequestBytes = 1 << 20
+
+// maxTimeOffset bounds the timeOffset option to the offsets of time zones,
+// with some slack.
+const maxTimeOffset = 24 * time.Hour
+
+// aggregatorTagKey is the series tag holding the aggregator of the sub query
+// when aggregatorTag is set. The underscore keeps it apart from OpenTSDB tags.
+const aggregatorTagKey = "_aggregator"
+
 func init() {
 	plog = log.New("tsdb.opentsdb")
 	tsdb.RegisterTsdbQueryEndpoint("opentsdb", NewOpenTsdbExecutor)
 }
 
 func (e *OpenTsdbExecutor) Query(ctx context.Context, dsInfo *models.DataSource, queryContext *tsdb.TsdbQuery) (*tsdb.Response, error) {
-	result := &tsdb.Response{}
+	result := &tsdb.Response{
+		Results: make(map[string]*tsdb.QueryResult),
+	}
+
+	timeout, err := queryTimeout(dsInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	var tsdbQuery OpenTsdbQuery
+	// Bound the load dashboards put on a shared OpenTSDB cluster.
+	release, err := acquireQuerySlot(ctx, dsInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	tsdbQuery.Start = queryContext.TimeRange.GetFromAsMsEpoch()
-	tsdbQuery.End = queryContext.TimeRange.GetToAsMsEpoch()
+	// Name the datasource, the error ends up on panels of dashboards that may
+	// use many.
+	httpClient, err := dsInfo.GetHttpClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build HTTP client for OpenTSDB datasource '%s': %v", dsInfo.Name, err)
+	}
 
-	for _, query := range queryContext.Queries {
-		metric := e.buildMetric(query)
-		tsdbQuery.Queries = append(tsdbQuery.Queries, metric)
+	if len(queryContext.Queries) > 0 {
+		switch queryContext.Queries[0].Model.Get("type").MustString() {
+		case "metricFindQuery":
+			return e.executeMetricFindQuery(ctx, dsInfo, httpClient, queryContext)
+		case "annotationQuery":
+			return e.executeAnnotationQuery(ctx, dsInfo, httpClient, queryContext)
+		}
 	}
 
-	if setting.Env == setting.DEV {
-		plog.Debug("OpenTsdb request", "params", tsdbQuery)
+	for i, query := range queryContext.Queries {
+		client, relaxed, err := queryHttpClient(dsInfo, httpClient, query)
+		if err != nil {
+			return nil, err
+		}
+		targetCtx, cancel := targetContext(ctx, len(queryContext.Queries)-i)
+		queryRes, err := e.metricsRequest(targetCtx, dsInfo, client, queryContext.TimeRange, query)
+		cancel()
+		// A target running out of its share of the deadline only fails
+		// itself, the others may still make it.
+		if err != nil && targetCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			result.Results[query.RefId] = &tsdb.QueryResult{RefId: query.RefId, Error: err}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Flagged so that panels can tell results weren't fetched securely.
+		if relaxed {
+			if queryRes.Meta == nil {
+				queryRes.Meta = simplejson.New()
+			}
+			queryRes.Meta.Set("relaxedTLS", true)
+		}
+		result.Results[query.RefId] = queryRes
 	}
 
-	req, err := e.createRequest(dsInfo, tsdbQuery)
+	return result, nil
+}
+
+// metricsRequest runs a single target against the OpenTSDB query endpoint.
+// Every target gets its own request so that its results, and the options
+// post-processing them, can't be confused with the ones of other targets.
+func (e *OpenTsdbExecutor) metricsRequest(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, timeRange *tsdb.TimeRange, query *tsdb.Query) (*tsdb.QueryResult, error) {
+	headers, err := queryHeaders(query)
 	if err != nil {
 		return nil, err
 	}
 
-	httpClient, err := dsInfo.GetHttpClient()
+	// Dynamic dashboards checking whether a series exists don't need data.
+	if query.Model.Get("existsOnly").MustBool() {
+		exists, err := e.seriesExists(ctx, dsInfo, httpClient, headers, query)
+		if err != nil {
+			return nil, err
+		}
+		queryRes := tsdb.NewQueryResult()
+		queryRes.Meta = simplejson.New()
+		queryRes.Meta.Set("exists", exists)
+		queryRes.RefId = query.RefId
+		return queryRes, nil
+	}
+
+	metrics, err := e.buildMetrics(ctx, dsInfo, httpClient, query)
 	if err != nil {
 		return nil, err
 	}
+	metrics, err = e.expandMetrics(ctx, dsInfo, httpClient, query, metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	// Targets expanded into several sub queries need the sub queries echoed
+	// back to tell which one a series belongs to.
+	tsdbQuery := OpenTsdbQuery{
+		Start:        timeRange.GetFromAsMsEpoch(),
+		End:          timeRange.GetToAsMsEpoch(),
+		Queries:      metrics,
+		MsResolution: dsInfo.JsonData.Get("tsdbResolution").MustInt(1) == 2,
+		ShowQuery:    len(metrics) > 1,
+		ShowStats:    query.Model.Get("showStats").MustBool(),
+		ShowSummary:  query.Model.Get("showSummary").MustBool(),
+		UseMeta:      query.Model.Get("useMeta").MustBool(),
+		ShowTSUIDs:   query.Model.Get("showTSUIDs").MustBool(),
+	}
 
-	res, err := ctxhttp.Do(ctx, httpClient, req)
+	// Relative times are resolved against the clock of OpenTSDB rather than
+	// the one of Grafana.
+	if query.Model.Get("relativeTime").MustBool() {
+		if query.Model.Get("chunked").MustBool() {
+			return nil, errors.New("relativeTime can't be combined with chunked queries")
+		}
+		if tsdbQuery.RelativeStart, tsdbQuery.RelativeEnd, err = relativeTimeRange(timeRange); err != nil {
+			return nil, err
+		}
+	}
+
+	// Millisecond edges can shift the downsample buckets of data stored at
+	// second resolution.
+	if query.Model.Get("roundToSeconds").MustBool() {
+		tsdbQuery.Start, tsdbQuery.End = roundToSeconds(tsdbQuery.Start, tsdbQuery.End)
+	}
+
+	// Fine intervals over long ranges can overload OpenTSDB whatever the
+	// point limit.
+	bucketWarnings, err := limitBuckets(dsInfo, tsdbQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	queryResult, err := e.parseResponse(tsdbQuery, res)
+	// Shared clusters are better protected by refusing a query than by
+	// running it.
+	if maxCost := dsInfo.JsonData.Get("maxQueryCost").MustInt64(); maxCost > 0 {
+		cost, err := e.estimateCost(ctx, dsInfo, httpClient, headers, tsdbQuery)
+		if err != nil {
+			return nil, err
+		}
+		if cost > maxCost {
+			return nil, fmt.Errorf("Query too expensive: it would return about %d data points, more than the maxQueryCost limit of %d. Narrow the time range or the tags, or downsample to a coarser interval", cost, maxCost)
+		}
+	}
+
+	// Only metric names and the time range are logged, tag values may carry
+	// sensitive dimensions.
+	if setting.Env == setting.DEV && dsInfo.JsonData.Get("logQueries").MustBool() {
+		plog.Debug("OpenTsdb request", "metrics", queryMetrics(tsdbQuery), "start", tsdbQuery.Start, "end", tsdbQuery.End)
+	}
+
+	chunk, err := chunkDuration(query)
 	if err != nil {
 		return nil, err
 	}
 
-	result.Results = queryResult
-	return result, nil
+	explainEmpty := query.Model.Get("explainEmpty").MustBool()
+	send := func(data OpenTsdbQuery) (*tsdb.QueryResult, error) {
+		queryRes, err := e.queryRequest(ctx, dsInfo, httpClient, query, data)
+		if explainEmpty {
+			return unknownMetricResult(queryRes, err)
+		}
+		return queryRes, err
+	}
+
+	var queryRes *tsdb.QueryResult
+	if chunk > 0 {
+		chunks := queryChunks(tsdbQuery, chunk)
+		results := make([]*tsdb.QueryResult, 0, len(chunks))
+		for _, chunkQuery := range chunks {
+			chunkRes, err := send(chunkQuery)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, chunkRes)
+		}
+		queryRes = mergeChunks(results)
+	} else {
+		queryRes, err = send(tsdbQuery)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Unknown metrics and metrics without data in the range both come back
+	// empty. Telling them apart costs a suggest request per metric, so it's
+	// opt-in.
+	if explainEmpty && len(queryRes.Series) == 0 {
+		if err := e.explainEmptyResult(ctx, dsInfo, httpClient, headers, tsdbQuery, queryRes); err != nil {
+			return nil, err
+		}
+	}
+
+	warnings, err := downsampleWarnings(dsInfo, tsdbQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range append(bucketWarnings, warnings...) {
+		addWarning(queryRes, warning)
+	}
+
+	// Report what was sent once defaults and macros were resolved, an empty
+	// string meaning raw data.
+	if downsample, ok := sentDownsample(tsdbQuery); ok {
+		queryRes.Meta.Set("downsample", downsample)
+	}
+
+	// Counted before the total is added, so that it reflects the series
+	// OpenTSDB matched.
+	queryRes.Meta.Set("seriesCount", len(queryRes.Series))
+
+	// Sorted before the total is added, which stays the last series.
+	if query.Model.Get("sortSeries").MustBool() {
+		sortSeriesByName(queryRes.Series)
+	}
+
+	// Totals are computed here so that the per series breakdown is kept too.
+	if aggregator := query.Model.Get("postAggregate").MustString(); aggregator != "" {
+		total, err := aggregateSeries(queryRes.Series, query.Model.Get("metric").MustString(), aggregator)
+		if err != nil {
+			return nil, err
+		}
+		if len(queryRes.Series) > 0 {
+			queryRes.Series = append(queryRes.Series, total)
+		}
+	}
+
+	// Alert rules meant to fire on missing data need an explicit signal
+	// rather than an empty result.
+	switch mode := query.Model.Get("emptyResult").MustString(dsInfo.JsonData.Get("emptyResult").MustString("empty")); mode {
+	case "empty":
+	case "noData":
+		if len(queryRes.Series) == 0 {
+			queryRes.Meta.Set("noData", true)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid emptyResult %q: should be empty or noData", mode)
+	}
+
+	if query.Model.Get("dataframes").MustBool() {
+		if err := encodeFrames(queryRes); err != nil {
+			return nil, err
+		}
+	}
+
+	queryRes.RefId = query.RefId
+	return queryRes, nil
+}
+
+// apiURL builds the URL of an OpenTSDB HTTP API endpoint such as "query",
+// placing it below the datasource's configurable API path prefix.
+func apiURL(dsInfo *models.DataSource, endpoint string) (*url.URL, error) {
+	u, err := url.Parse(dsInfo.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Base URLs are entered with and without trailing slashes, and sometimes
+	// already end with the API prefix.
+	base := path.Clean("/" + u.Path)
+	prefix := strings.Trim(dsInfo.JsonData.Get("apiPathPrefix").MustString("api"), "/")
+	if prefix != "" && strings.HasSuffix(base, "/"+prefix) {
+		base = strings.TrimSuffix(base, prefix)
+	}
+	u.Path = path.Join(base, prefix, endpoint)
+
+	return u, nil
+}
+
+// tsdbVersion returns the OpenTSDB version the datasource is configured for.
+func tsdbVersion(dsInfo *models.DataSource) int {
+	return dsInfo.JsonData.Get("tsdbVersion").MustInt(tsdbVersion21)
+}
+
+// queryMetrics returns the metric names requested by the sub queries of data.
+func queryMetrics(data OpenTsdbQuery) []string {
+	metrics := make([]string, 0, len(data.Queries))
+	for _, query := range data.Queries {
+		if metric, ok := query["metric"].(string); ok {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
+
+// roundToSeconds widens a time range in milliseconds to whole seconds,
+// rounding start down and end up.
+func roundToSeconds(start int64, end int64) (int64, int64) {
+	start -= start % 1000
+	if remainder := end % 1000; remainder != 0 {
+		end += 1000 - remainder
+	}
+	return start, end
+}
+
+// queryTimeout returns the datasource's configured query timeout, or zero when
+// queries should only be bounded by the HTTP client.
+func queryTimeout(dsInfo *models.DataSource) (time.Duration, error) {
+	value := dsInfo.JsonData.Get("queryTimeout").MustString()
+	if value == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("Invalid queryTimeout %q: should be a duration like 30s", value)
+	}
+
+	return timeout, nil
 }
 
-func (e *OpenTsdbExecutor) createRequest(dsInfo *models.DataSource, data OpenTsdbQuery) (*http.Request, error) {
-	u, _ := url.Parse(dsInfo.Url)
-	u.Path = path.Join(u.Path, "api/query")
+// requestOptions are the JSON names of the top level fields of requests.
+// OpenTSDB ignores them inside sub queries, so finding them there is a bug.
+var requestOptions = jsonFieldNames(reflect.TypeOf(OpenTsdbQuery{}))
+
+// jsonFieldNames returns the sorted JSON names of the fields of struct type t.
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *OpenTsdbExecutor) createRequest(ctx context.Context, dsInfo *models.DataSource, data OpenTsdbQuery, headers http.Header) (*http.Request, error) {
+	u, err := apiURL(dsInfo, "query")
+	if err != nil {
+		plog.Info("Failed to parse datasource url", "error", err)
+		return nil, fmt.Errorf("Failed to create request. error: %v", err)
+	}
+
+	// The query endpoint deletes the matching data points when a sub query
+	// carries "delete": true. Never let that reach OpenTSDB.
+	for _, subQuery := range data.Queries {
+		delete(subQuery, "delete")
+		for _, option := range requestOptions {
+			if _, ok := subQuery[option]; ok {
+				return nil, fmt.Errorf("Invalid sub query option %s: should be set on the request", option)
+			}
+		}
+	}
 
 	postData, err := json.Marshal(data)
 	if err != nil {
@@ -88,71 +417,598 @@ func (e *OpenTsdbExecutor) createRequest(dsInfo *models.DataSource, data OpenTsd
 		return nil, fmt.Errorf("Failed to create request. error: %v", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(postData)))
+	// Proxies in front of OpenTSDB tend to reject large bodies with errors that
+	// say little about the query, so refuse to send them in the first place.
+	if limit := dsInfo.JsonData.Get("maxRequestBytes").MustInt(defaultMaxRequestBytes); limit > 0 && len(postData) > limit {
+		return nil, fmt.Errorf("Query is too large: %d bytes exceed the maxRequestBytes limit of %d bytes. Use filters instead of listing many tag values", len(postData), limit)
+	}
+
+	req, err := newRequest(dsInfo, http.MethodPost, u.String(), strings.NewReader(string(postData)), headers)
 	if err != nil {
-		plog.Info("Failed to create request", "error", err)
-		return nil, fmt.Errorf("Failed to create request. error: %v", err)
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if dsInfo.BasicAuth {
-		req.SetBasicAuth(dsInfo.BasicAuthUser, dsInfo.DecryptedBasicAuthPassword())
+
+	// Some OpenTSDB deployments bound server-side execution with a timeout hint
+	// header. Send the time left to the request in milliseconds, at most the
+	// query timeout, so the server can abort scans that nobody is waiting for
+	// anymore. Targets only get their share of the panel's deadline.
+	if header := dsInfo.JsonData.Get("queryTimeoutHeader").MustString(); header != "" {
+		timeout, err := queryTimeout(dsInfo)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			if left := time.Until(deadline); timeout <= 0 || left < timeout {
+				timeout = left
+			}
+		}
+		if timeout > 0 {
+			req.Header.Set(header, strconv.FormatInt(timeout.Milliseconds(), 10))
+		}
 	}
 
-	return req, err
+	return req, nil
 }
 
-func (e *OpenTsdbExecutor) parseResponse(query OpenTsdbQuery, res *http.Response) (map[string]*tsdb.QueryResult, error) {
+// checkWritable must be called first by every code path issuing a write to
+// OpenTSDB, such as annotation write-back or dropping caches, so that
+// datasources shared read-only can't be used to modify data.
+func checkWritable(dsInfo *models.DataSource) error {
+	if dsInfo.JsonData.Get("readOnly").MustBool() {
+		return errReadOnly
+	}
+	return nil
+}
 
-	queryResults := make(map[string]*tsdb.QueryResult)
-	queryRes := tsdb.NewQueryResult()
+// applyAuth adds the datasource's credentials to a request.
+func applyAuth(dsInfo *models.DataSource, req *http.Request) {
+	if dsInfo.BasicAuth {
+		req.SetBasicAuth(dsInfo.BasicAuthUser, dsInfo.DecryptedBasicAuthPassword())
+	}
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// readResponse reads and closes the body of an OpenTSDB response, failing on
+// any non successful status with an error formatted at the datasource's
+// errorDetail level. The body is closed on every path, including reads
+// aborted by a cancelled context, so that half read connections are dropped
+// instead of going back to the pool.
+func readResponse(dsInfo *models.DataSource, res *http.Response) ([]byte, error) {
 	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
+	if body, err = decodeCharset(body, res.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
 
 	if res.StatusCode/100 != 2 {
 		plog.Info("Request failed", "status", res.Status, "body", string(body))
-		return nil, fmt.Errorf("Request failed status: %v", res.Status)
+		err := newRequestError(res.Status, body)
+		err.StatusCode = res.StatusCode
+		err.Level = errorDetail(dsInfo)
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// subQueryIndex returns the index of the sub query a response element
+// answers. OpenTSDB only echoes the sub query when showQuery was set.
+func subQueryIndex(response OpenTsdbResponse, data OpenTsdbQuery) int {
+	if len(data.Queries) == 1 || response.Query == nil {
+		return 0
+	}
+
+	if index := response.Query.Index; index != nil && *index >= 0 && *index < len(data.Queries) {
+		return *index
+	}
+
+	// Versions before 2.2 don't report the index of the sub query.
+	for i, subQuery := range data.Queries {
+		downsample, _ := subQuery["downsample"].(string)
+		if subQuery["aggregator"] == response.Query.Aggregator && downsample == response.Query.Downsample {
+			return i
+		}
+	}
+	return 0
+}
+
+func (e *OpenTsdbExecutor) parseResponse(dsInfo *models.DataSource, query *tsdb.Query, data OpenTsdbQuery, res *http.Response) (*tsdb.QueryResult, error) {
+
+	queryRes := tsdb.NewQueryResult()
+	queryRes.Meta = simplejson.New()
+
+	body, err := readResponse(dsInfo, res)
+	if err != nil {
+		return nil, err
+	}
+
+	// Which backend served a query isn't always obvious behind proxies.
+	if query.Model.Get("debugHeaders").MustBool() {
+		queryRes.Meta.Set("responseHeaders", debugHeaders(res.Header))
 	}
 
-	var data []OpenTsdbResponse
-	err = json.Unmarshal(body, &data)
+	// Some proxies answer empty result sets with 204 No Content or an empty
+	// body rather than with an empty list.
+	if res.StatusCode == http.StatusNoContent || len(bytes.TrimSpace(body)) == 0 {
+		return queryRes, nil
+	}
+	body = replaceNonFiniteValues(body)
+
+	responses, err := unmarshalResponses(body, responseFieldNames(dsInfo))
 	if err != nil {
 		plog.Info("Failed to unmarshal opentsdb response", "error", err, "status", res.Status, "body", string(body))
 		return nil, err
 	}
 
-	for _, val := range data {
+	// Duplicated series fail by default, rather than showing up as two
+	// identical lines.
+	responses, err = mergeDuplicates(responses, data, query.Model.Get("duplicateSeries").MustString("error"))
+	if err != nil {
+		return nil, err
+	}
+
+	responses, stats := queryStats(responses)
+	if stats != nil {
+		queryRes.Meta.Set("stats", stats)
+	}
+
+	// Queries returning very many series are usually missing a tag filter.
+	// Past warnSeries they are flagged, past maxSeries they are refused.
+	if maxSeries := dsInfo.JsonData.Get("maxSeries").MustInt(); maxSeries > 0 && len(responses) > maxSeries {
+		return nil, fmt.Errorf("Query returned %d series, more than the maxSeries limit of %d. Narrow the query with tag filters", len(responses), maxSeries)
+	}
+	if warnSeries := dsInfo.JsonData.Get("warnSeries").MustInt(); warnSeries > 0 && len(responses) > warnSeries {
+		addWarning(queryRes, fmt.Sprintf("Query returned %d series, more than %d. Consider narrowing it with tag filters", len(responses), warnSeries))
+	}
+
+	// rawResponse is an unstable escape hatch for custom visualizations that
+	// need parts of the response which aren't modeled as series yet.
+	if query.Model.Get("rawResponse").MustBool() {
+		var raw interface{}
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+		queryRes.Meta.Set("rawResponse", raw)
+	}
+
+	fillNullPoints := query.Model.Get("fillNulls").MustBool()
+	carryForward := query.Model.Get("carryForward").MustBool()
+	alignSeries := query.Model.Get("alignSeries").MustBool()
+	boundaryNulls := query.Model.Get("boundaryNulls").MustBool()
+	prefix := query.Model.Get("seriesPrefix").MustString()
+	_, multiAggregators := query.Model.CheckGet("multiAggregators")
+	_, overlayDownsamples := query.Model.CheckGet("overlayDownsamples")
+	aggregatorTag := query.Model.Get("aggregatorTag").MustBool()
+	tagsInName := query.Model.Get("tagsInName").MustBool()
+	flattenTags := query.Model.Get("flattenTags").MustBool()
+	dropAllNulls := query.Model.Get("dropAllNullSeries").MustBool()
+	rateZeroGaps := query.Model.Get("rateZeros").MustString() == "gap"
+	detectResolution := dsInfo.JsonData.Get("detectResolution").MustBool()
+	offset, err := timeOffset(query)
+	if err != nil {
+		return nil, err
+	}
+	var percentiles []percentileSeries
+	aggregatedTags := make(map[string]bool)
+	truncated := 0
+	unitMappings := dsInfo.JsonData.Get("unitMappings").MustMap()
+	units := make(map[string]string)
+	keys := make(map[string]string)
+	pointTimes := query.Model.Get("seriesTimes").MustBool()
+	times := make(map[string]map[string]float64)
+	colorTag := query.Model.Get("colorByTag").MustString()
+	colors := make(map[string]string)
+
+	for _, val := range responses {
 		series := tsdb.TimeSeries{
 			Name: val.Metric,
+			Tags: make(map[string]string, len(val.Tags)),
+		}
+		for key, value := range val.Tags {
+			series.Tags[key] = value
 		}
+		subQuery := data.Queries[subQueryIndex(val, data)]
 
+		if !groupsBy(subQuery) {
+			for _, tag := range val.AggregateTags {
+				aggregatedTags[tag] = true
+			}
+		}
+
+		_, isPercentileQuery := subQuery["percentiles"]
+		metric, percentile, isPercentile := responsePercentile(val)
+		if isPercentileQuery && isPercentile {
+			series.Name = percentileSeriesName(metric, percentile)
+		}
+		if tagsInName || flattenTags {
+			series.Name = formatSeriesName(series.Name, val.Tags)
+		}
+		// Flattened series carry their tags in the name only, for panels
+		// that would otherwise list them again.
+		if flattenTags {
+			series.Tags = make(map[string]string)
+		}
+
+		if multiAggregators {
+			series.Name = fmt.Sprintf("%s (%s)", series.Name, subQuery["aggregator"])
+		}
+		if overlayDownsamples {
+			series.Name = fmt.Sprintf("%s (%s)", series.Name, overlayName(subQuery))
+		}
+
+		if aggregator, ok := subQuery["aggregator"].(string); ok && aggregatorTag {
+			series.Tags[aggregatorTagKey] = aggregator
+		}
+
+		if prefix != "" {
+			series.Name = prefix + ": " + series.Name
+		}
+
+		msResolution := data.MsResolution
+		if detectResolution {
+			msResolution = isMsResolution(val.DataPoints)
+		}
 		for timeString, value := range val.DataPoints {
 			timestamp, err := strconv.ParseFloat(timeString, 64)
 			if err != nil {
 				plog.Info("Failed to unmarshal opentsdb timestamp", "timestamp", timeString)
 				return nil, err
 			}
-			series.Points = append(series.Points, tsdb.NewTimePoint(null.FloatFrom(value), timestamp))
+			if !msResolution {
+				timestamp *= 1000
+			}
+			series.Points = append(series.Points, tsdb.NewTimePoint(value.Float, timestamp))
+		}
+
+		if rateZeroGaps && subQuery["rate"] == true {
+			for i := range series.Points {
+				if series.Points[i][0].Valid && series.Points[i][0].Float64 == 0 {
+					series.Points[i][0] = null.FloatFromPtr(nil)
+				}
+			}
+		}
+
+		// dps is a JSON object, so points come out of it in random order.
+		sort.Slice(series.Points, func(i, j int) bool {
+			return series.Points[i][1].Float64 < series.Points[j][1].Float64
+		})
+
+		// Taken before fills, which would make stale series look fresh.
+		if pointTimes {
+			if first, last, ok := validPointRange(series.Points); ok {
+				times[seriesKey(&series)] = map[string]float64{
+					"first": first + float64(offset.Milliseconds()),
+					"last":  last + float64(offset.Milliseconds()),
+				}
+			}
+		}
+
+		// Aligned series share the timestamps of the interval grid, with nulls
+		// where they lack points unless those are carried forward.
+		if fillNullPoints || carryForward || alignSeries {
+			interval, err := downsampleInterval(subQuery)
+			if err != nil {
+				return nil, err
+			}
+			if alignSeries {
+				series.Points = alignPoints(series.Points, interval)
+			}
+			if carryForward {
+				series.Points, err = fillCarryForward(series.Points, data.Start, data.End, interval, maxFillBuckets(dsInfo))
+			} else {
+				series.Points, err = fillNulls(series.Points, data.Start, data.End, interval, maxFillBuckets(dsInfo))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if boundaryNulls {
+			series.Points = addBoundaryNulls(series.Points, data.Start, data.End)
+		}
+
+		if offset != 0 {
+			for i := range series.Points {
+				series.Points[i][1].Float64 += float64(offset.Milliseconds())
+			}
+		}
+
+		// Servers ignoring the limit of the sub query get it enforced here.
+		if limit, ok := subQuery["limit"].(int); ok && len(series.Points) > limit {
+			series.Points = series.Points[:limit]
+			truncated++
+		}
+
+		// All null series are kept by default, so that legends don't lose
+		// series that merely had no data in the range.
+		if dropAllNulls && allNulls(series.Points) {
+			continue
+		}
+
+		if unit := metricUnit(unitMappings, val.Metric); unit != "" {
+			units[seriesKey(&series)] = unit
+		}
+		if len(val.TSUIDs) > 0 {
+			keys[seriesKey(&series)] = responseKey(val)
+		}
+		// Taken from the response, flattened series have no tags left.
+		if value, ok := val.Tags[colorTag]; ok && colorTag != "" {
+			colors[seriesKey(&series)] = seriesColor(value)
+		}
+
+		if isPercentileQuery && isPercentile {
+			percentiles = append(percentiles, percentileSeries{series: &series, metric: metric, percentile: percentile})
+			continue
 		}
 
 		queryRes.Series = append(queryRes.Series, &series)
 	}
 
-	queryResults["A"] = queryRes
-	return queryResults, nil
+	if len(percentiles) > 0 {
+		queryRes.Series = append(queryRes.Series, sortPercentileSeries(percentiles)...)
+	}
+
+	if truncated > 0 {
+		addWarning(queryRes, fmt.Sprintf("%d series were cut off at the point limit. Increase the downsample interval to see the whole time range", truncated))
+	}
+
+	// Series lumped together across tags are a common surprise for queries
+	// lacking any groupBy filter.
+	if len(aggregatedTags) > 0 {
+		tags := make([]string, 0, len(aggregatedTags))
+		for tag := range aggregatedTags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		addWarning(queryRes, fmt.Sprintf("Series are aggregated across the tags %s. Add a groupBy filter to see them separately", strings.Join(tags, ", ")))
+	}
+
+	// Unit hints spare panels from configuring units metric by metric.
+	if len(units) > 0 {
+		queryRes.Meta.Set("seriesUnits", units)
+	}
+
+	if len(times) > 0 {
+		queryRes.Meta.Set("seriesTimes", times)
+	}
+
+	// Panels keying overrides by these keep them when tag values change.
+	if len(keys) > 0 {
+		queryRes.Meta.Set("seriesKeys", keys)
+	}
+
+	// Color hints are advisory, panels are free to ignore them.
+	if colorTag != "" {
+		queryRes.Meta.Set("seriesColors", colors)
+	}
+
+	annotations := filterAnnotations(responseAnnotations(responses), query.Model.Get("annotationFilter").MustMap())
+	if len(annotations) > 0 {
+		queryRes.Meta.Set("annotations", annotations)
+	}
+
+	return queryRes, nil
+}
+
+// queryRequest sends data to the query endpoint and parses the response.
+func (e *OpenTsdbExecutor) queryRequest(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, query *tsdb.Query, data OpenTsdbQuery) (*tsdb.QueryResult, error) {
+	headers, err := queryHeaders(query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := e.createRequest(ctx, dsInfo, data, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var command string
+	if query.Model.Get("curlCommand").MustBool() {
+		if command, err = curlCommand(req); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := doRequest(ctx, dsInfo, httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	queryRes, err := e.parseResponse(dsInfo, query, data, res)
+	if err != nil {
+		return nil, err
+	}
+	if command != "" {
+		queryRes.Meta.Set("curlCommand", command)
+	}
+
+	// Meta based queries miss series absent from an incomplete meta table.
+	// When they come back empty, pay for the full scan instead.
+	if data.UseMeta && len(queryRes.Series) == 0 && query.Model.Get("useMetaFallback").MustBool() {
+		data.UseMeta = false
+		return e.queryRequest(ctx, dsInfo, httpClient, query, data)
+	}
+
+	return queryRes, nil
 }
 
-func (e *OpenTsdbExecutor) buildMetric(query *tsdb.Query) map[string]interface{} {
+// buildMetrics builds the OpenTSDB sub queries of a target. A target asking
+// for several aggregators is expanded into one sub query per aggregator,
+// which are all sent in the same request.
+func (e *OpenTsdbExecutor) buildMetrics(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, query *tsdb.Query) ([]map[string]interface{}, error) {
+	metric, err := e.buildMetric(ctx, dsInfo, httpClient, query)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []map[string]interface{}{metric}
+	if aggregators, ok := query.Model.CheckGet("multiAggregators"); ok {
+		if metrics, err = aggregatorMetrics(query, metric, aggregators); err != nil {
+			return nil, err
+		}
+	}
+	if downsamples, ok := query.Model.CheckGet("overlayDownsamples"); ok {
+		if metrics, err = overlayMetrics(metrics, downsamples); err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
+
+// aggregatorMetrics expands metric into one sub query per aggregator of the
+// multiAggregators option.
+func aggregatorMetrics(query *tsdb.Query, metric map[string]interface{}, aggregators *simplejson.Json) ([]map[string]interface{}, error) {
+	values, err := aggregators.StringArray()
+	if err != nil || len(values) == 0 {
+		return nil, errors.New("multiAggregators should be a list of aggregators")
+	}
+
+	// Aggregators can override the downsample of the target, such as max
+	// over 1h next to avg over 5m.
+	downsamples := query.Model.Get("aggregatorDownsamples").MustMap()
+	for aggregator, downsample := range downsamples {
+		value, ok := downsample.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid aggregatorDownsamples downsample for %q: should be a string", aggregator)
+		}
+		if !containsString(values, aggregator) {
+			return nil, fmt.Errorf("Invalid aggregatorDownsamples aggregator %q: should be one of multiAggregators", aggregator)
+		}
+		if err := checkDownsample("aggregatorDownsamples", value); err != nil {
+			return nil, err
+		}
+	}
+
+	metrics := make([]map[string]interface{}, 0, len(values))
+	for _, aggregator := range values {
+		if aggregator == "" {
+			return nil, errors.New("multiAggregators should not contain empty aggregators")
+		}
+		downsample, override := downsamples[aggregator]
+		if err := checkTemplateResolved("multiAggregators", aggregator); err != nil {
+			return nil, err
+		}
+		aggregator, err := interpolationAggregator(aggregator, query.Model.Get("interpolation").MustString())
+		if err != nil {
+			return nil, err
+		}
+		if aggregator, err = percentileAggregator(aggregator, query.Model.Get("percentileEstimation").MustString()); err != nil {
+			return nil, err
+		}
+
+		subQuery := copyMetric(metric)
+		subQuery["aggregator"] = aggregator
+		if override {
+			setDownsample(subQuery, downsample.(string))
+		}
+		metrics = append(metrics, subQuery)
+	}
+
+	return metrics, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayMetrics expands each of metrics into one sub query per downsample of
+// the overlayDownsamples option, where an empty downsample queries raw data.
+func overlayMetrics(metrics []map[string]interface{}, downsamples *simplejson.Json) ([]map[string]interface{}, error) {
+	values, err := downsamples.StringArray()
+	if err != nil || len(values) == 0 {
+		return nil, errors.New("overlayDownsamples should be a list of downsamples")
+	}
+
+	overlays := make([]map[string]interface{}, 0, len(metrics)*len(values))
+	for _, metric := range metrics {
+		for _, downsample := range values {
+			if err := checkDownsample("overlayDownsamples", downsample); err != nil {
+				return nil, err
+			}
+
+			subQuery := copyMetric(metric)
+			setDownsample(subQuery, downsample)
+			overlays = append(overlays, subQuery)
+		}
+	}
+
+	return overlays, nil
+}
+
+// checkDownsample checks a downsample given by option to override the one of
+// a sub query, where an empty downsample queries raw data.
+func checkDownsample(option string, downsample string) error {
+	if err := checkTemplateResolved(option, downsample); err != nil {
+		return err
+	}
+	if downsample != "" && !strings.Contains(downsample, "-") {
+		return fmt.Errorf("Invalid %s downsample %q: should be like 1h-avg", option, downsample)
+	}
+	return nil
+}
+
+// setDownsample replaces the downsample of subQuery, removing it when
+// downsample is empty.
+func setDownsample(subQuery map[string]interface{}, downsample string) {
+	delete(subQuery, "downsample")
+	if downsample != "" {
+		subQuery["downsample"] = downsample
+	}
+}
+
+// overlayName returns the name overlayDownsamples gives to the series of
+// subQuery.
+func overlayName(subQuery map[string]interface{}) string {
+	if downsample, ok := subQuery["downsample"].(string); ok {
+		return downsample
+	}
+	return "raw"
+}
+
+func copyMetric(metric map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(metric))
+	for key, value := range metric {
+		copied[key] = value
+	}
+	return copied
+}
+
+func (e *OpenTsdbExecutor) buildMetric(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, query *tsdb.Query) (map[string]interface{}, error) {
 
 	metric := make(map[string]interface{})
 
 	// Setting metric and aggregator
 	metric["metric"] = query.Model.Get("metric").MustString()
-	metric["aggregator"] = query.Model.Get("aggregator").MustString()
+	aggregator := query.Model.Get("aggregator").MustString()
+	if err := checkTemplateResolved("aggregator", aggregator); err != nil {
+		return nil, err
+	}
+	// OpenTSDB rejects empty aggregators with an error that doesn't say which
+	// field is wrong. Sub queries of multiAggregators get theirs later.
+	if _, multiAggregators := query.Model.CheckGet("multiAggregators"); aggregator == "" && !multiAggregators {
+		aggregator = dsInfo.JsonData.Get("defaultAggregator").MustString()
+		if aggregator == "" {
+			return nil, errors.New("Query has no aggregator. Pick one or set a default aggregator for the datasource")
+		}
+	}
+	aggregator, err := interpolationAggregator(aggregator, query.Model.Get("interpolation").MustString())
+	if err != nil {
+		return nil, err
+	}
+	if aggregator, err = percentileAggregator(aggregator, query.Model.Get("percentileEstimation").MustString()); err != nil {
+		return nil, err
+	}
+	metric["aggregator"] = aggregator
 
 	// Setting downsampling options
 	disableDownsampling := query.Model.Get("disableDownsampling").MustBool()
@@ -161,12 +1017,67 @@ func (e *OpenTsdbExecutor) buildMetric(query *tsdb.Query) map[string]interface{}
 		if downsampleInterval == "" {
 			downsampleInterval = "1m" //default value for blank
 		}
-		downsample := downsampleInterval + "-" + query.Model.Get("downsampleAggregator").MustString()
-		if query.Model.Get("downsampleFillPolicy").MustString() != "none" {
-			metric["downsample"] = downsample + "-" + query.Model.Get("downsampleFillPolicy").MustString()
+		downsampleInterval, err = replaceIntervalMacros(query, downsampleInterval)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTemplateResolved("downsampleInterval", downsampleInterval); err != nil {
+			return nil, err
+		}
+		// Run all downsampling reduces the whole time range to a single point.
+		if query.Model.Get("runAll").MustBool() {
+			if err := requireVersion(ctx, dsInfo, httpClient, query, "2.3", "runAll downsampling"); err != nil {
+				return nil, err
+			}
+			downsampleInterval = "0all"
+		}
+		downsampleAggregator := query.Model.Get("downsampleAggregator").MustString()
+		if err := checkTemplateResolved("downsampleAggregator", downsampleAggregator); err != nil {
+			return nil, err
+		}
+		if downsampleAggregator == "" {
+			// avg is what the query editor picks for new queries.
+			downsampleAggregator = dsInfo.JsonData.Get("defaultDownsampleAggregator").MustString("avg")
+		}
+		downsampleAggregator, err := percentileAggregator(downsampleAggregator, query.Model.Get("percentileEstimation").MustString())
+		if err != nil {
+			return nil, err
+		}
+		downsample := downsampleInterval + "-" + downsampleAggregator
+		fillPolicy := query.Model.Get("downsampleFillPolicy").MustString()
+		// An empty fill policy would leave a trailing dash in the downsample.
+		// Client side fills replace the default fill policy of the datasource.
+		clientFill := query.Model.Get("fillNulls").MustBool() || query.Model.Get("carryForward").MustBool()
+		if fillPolicy == "" && !clientFill {
+			fillPolicy = dsInfo.JsonData.Get("defaultFillPolicy").MustString()
+		}
+		if fillPolicy == "" {
+			fillPolicy = "none"
+		}
+		if _, fillScalar := query.Model.CheckGet("fillScalar"); fillScalar || fillPolicy == "scalar" {
+			return nil, errors.New("scalar fill requires exp query type")
+		}
+		if err := checkFillOptions(query, fillPolicy); err != nil {
+			return nil, err
+		}
+		if fillPolicy != "none" {
+			metric["downsample"] = downsample + "-" + fillPolicy
 		} else {
 			metric["downsample"] = downsample
 		}
+
+		// Rollups are only read by OpenTSDB when asked to. Fall back to finer
+		// rollups and raw data for ranges the rollups don't cover.
+		if query.Model.Get("preferRollups").MustBool() {
+			if err := requireVersion(ctx, dsInfo, httpClient, query, "2.4", "preferRollups"); err != nil {
+				return nil, err
+			}
+			if interval, err := parseInterval(downsampleInterval); err == nil {
+				if hasRollup(rollupIntervals(dsInfo), interval) {
+					metric["rollupUsage"] = "ROLLUP_FALLBACK"
+				}
+			}
+		}
 	}
 
 	// Setting rate options
@@ -179,6 +1090,13 @@ func (e *OpenTsdbExecutor) buildMetric(query *tsdb.Query) map[string]interface{}
 		counterMax, counterMaxCheck := query.Model.CheckGet("counterMax")
 		if counterMaxCheck {
 			rateOptions["counterMax"] = counterMax.MustFloat64()
+		} else if bits, ok := query.Model.CheckGet("counterBits"); ok {
+			max, err := counterBitsMax(bits.MustInt())
+			if err != nil {
+				return nil, err
+			}
+			rateOptions["counterMax"] = max
+			counterMaxCheck = true
 		}
 
 		resetValue, resetValueCheck := query.Model.CheckGet("counterResetValue")
@@ -192,6 +1110,25 @@ func (e *OpenTsdbExecutor) buildMetric(query *tsdb.Query) map[string]interface{}
 
 		metric["rateOptions"] = rateOptions
 	}
+	// OpenTSDB reports rates past resetValue as 0. Zeros are kept as values
+	// by default, gap turns them into nulls in parseResponse.
+	if rateZeros := query.Model.Get("rateZeros").MustString(); rateZeros != "" && rateZeros != "keep" && rateZeros != "gap" {
+		return nil, fmt.Errorf("Invalid rateZeros %q: should be keep or gap", rateZeros)
+	}
+
+	// Setting histogram percentiles
+	if _, ok := query.Model.CheckGet("percentiles"); ok {
+		if err := requireVersion(ctx, dsInfo, httpClient, query, "2.4", "histogram percentiles"); err != nil {
+			return nil, err
+		}
+		percentiles, err := parsePercentiles(query.Model)
+		if err != nil {
+			return nil, err
+		}
+		if len(percentiles) > 0 {
+			metric["percentiles"] = percentiles
+		}
+	}
 
 	// Setting tags
 	tags, tagsCheck := query.Model.CheckGet("tags")
@@ -202,9 +1139,149 @@ func (e *OpenTsdbExecutor) buildMetric(query *tsdb.Query) map[string]interface{}
 	// Setting filters
 	filters, filtersCheck := query.Model.CheckGet("filters")
 	if filtersCheck && len(filters.MustArray()) > 0 {
-		metric["filters"] = filters.MustArray()
+		metric["filters"] = wildcardFilters(filters.MustArray())
+	}
+	if err := checkVariableExpansion(dsInfo, metric); err != nil {
+		return nil, err
+	}
+
+	// Setting the point limit, which bounds what a too fine downsample
+	// interval can return. autoLimit derives it from the panel's width.
+	limit := query.Model.Get("limit").MustInt()
+	if limit == 0 && query.Model.Get("autoLimit").MustBool() {
+		limit = int(query.MaxDataPoints)
+		if limit == 0 {
+			limit = query.Model.Get("maxDataPoints").MustInt()
+		}
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("Invalid limit %d: should be positive", limit)
+	}
+	if limit > 0 {
+		metric["limit"] = limit
+	}
+
+	return metric, nil
+
+}
+
+// addWarning adds a warning for the user to the warnings of queryRes.
+func addWarning(queryRes *tsdb.QueryResult, warning string) {
+	warnings := queryRes.Meta.Get("warnings").MustArray()
+	queryRes.Meta.Set("warnings", append(warnings, warning))
+}
+
+// counterBitsMax returns the counterMax of counters of the given width.
+// OpenTSDB reads counterMax as a signed 64 bit integer, so 64 bit counters
+// wrap at its largest value rather than at 2^64-1.
+func counterBitsMax(bits int) (int64, error) {
+	switch bits {
+	case 32:
+		return math.MaxUint32, nil
+	case 64:
+		return math.MaxInt64, nil
+	}
+	return 0, fmt.Errorf("Invalid counterBits %d: should be 32 or 64", bits)
+}
+
+// groupsBy reports whether a sub query groups series by any tag, through
+// groupBy filters or the tags of versions before 2.2.
+func groupsBy(subQuery map[string]interface{}) bool {
+	if tags, ok := subQuery["tags"].(map[string]interface{}); ok && len(tags) > 0 {
+		return true
+	}
+
+	filters, _ := subQuery["filters"].([]interface{})
+	for _, filter := range filters {
+		if filter, ok := filter.(map[string]interface{}); ok && filter["groupBy"] == true {
+			return true
+		}
+	}
+	return false
+}
+
+// sentDownsample returns the downsample spec sent with the sub queries of
+// data. It fails when they were downsampled differently, as overlays are.
+func sentDownsample(data OpenTsdbQuery) (string, bool) {
+	if len(data.Queries) == 0 {
+		return "", false
+	}
+
+	downsample, _ := data.Queries[0]["downsample"].(string)
+	for _, subQuery := range data.Queries[1:] {
+		if other, _ := subQuery["downsample"].(string); other != downsample {
+			return "", false
+		}
 	}
+	return downsample, true
+}
 
-	return metric
+// downsampleWarnings warns about sub queries of data downsampling to
+// intervals finer than the datasource's minDownsampleInterval, the resolution
+// its data is stored at. OpenTSDB answers them with sparse or repeated points.
+func downsampleWarnings(dsInfo *models.DataSource, data OpenTsdbQuery) ([]string, error) {
+	value := dsInfo.JsonData.Get("minDownsampleInterval").MustString()
+	if value == "" {
+		return nil, nil
+	}
+
+	resolution, err := parseInterval(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid minDownsampleInterval %q: should be an interval like 1m", value)
+	}
+
+	var warnings []string
+	for _, subQuery := range data.Queries {
+		interval, err := downsampleInterval(subQuery)
+		if err != nil || interval >= resolution {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("Downsample interval %v of %v is finer than the %s resolution of the data. Expect sparse or repeated points", interval, subQuery["metric"], value))
+	}
+	return warnings, nil
+}
+
+// timeOffset returns the query's timeOffset, the duration parsed point
+// timestamps are shifted by to show them in another time zone's wall-clock.
+func timeOffset(query *tsdb.Query) (time.Duration, error) {
+	value := query.Model.Get("timeOffset").MustString()
+	if value == "" {
+		return 0, nil
+	}
 
+	offset, err := time.ParseDuration(value)
+	if err != nil || offset < -maxTimeOffset || offset > maxTimeOffset {
+		return 0, fmt.Errorf("Invalid timeOffset %q: should be a duration between -24h and 24h like -5h30m", value)
+	}
+
+	return offset, nil
+}
+
+// checkFillOptions makes sure at most one way of filling missing points is
+// selected, client side fills can't be combined with a server fill policy.
+func checkFillOptions(query *tsdb.Query, fillPolicy string) error {
+	fills := make([]string, 0)
+	if query.Model.Get("fillNulls").MustBool() {
+		fills = append(fills, "fillNulls")
+	}
+	if query.Model.Get("carryForward").MustBool() {
+		fills = append(fills, "carryForward")
+	}
+	if len(fills) > 0 && fillPolicy != "none" && fillPolicy != "" {
+		fills = append(fills, "downsampleFillPolicy "+fillPolicy)
+	}
+
+	if len(fills) > 1 {
+		return fmt.Errorf("Fill options are mutually exclusive: %s", strings.Join(fills, ", "))
+	}
+	return nil
+}
+
+// checkTemplateResolved fails on values that still hold a template variable,
+// which happens when a dashboard variable couldn't be interpolated.
+func checkTemplateResolved(field string, value string) error {
+	if strings.HasPrefix(value, "$") {
+		return fmt.Errorf("unresolved template variable %s in %s", value, field)
+	}
+	return nil
 }
@@ -1,10 +1,22 @@
 package opentsdb
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/tsdb"
+	"github.com/grafana/grafana/pkg/util"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -12,6 +24,9 @@ func TestOpenTsdbExecutor(t *testing.T) {
 	Convey("OpenTsdb query testing", t, func() {
 
 		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{
+			JsonData: simplejson.New(),
+		}
 
 		Convey("Build metric with downsampling enabled", func() {
 
@@ -26,7 +41,8 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			query.Model.Set("downsampleAggregator", "avg")
 			query.Model.Set("downsampleFillPolicy", "none")
 
-			metric := exec.buildMetric(query)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
 
 			So(len(metric), ShouldEqual, 3)
 			So(metric["metric"], ShouldEqual, "cpu.average.percent")
@@ -48,7 +64,8 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			query.Model.Set("downsampleAggregator", "avg")
 			query.Model.Set("downsampleFillPolicy", "none")
 
-			metric := exec.buildMetric(query)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
 
 			So(len(metric), ShouldEqual, 2)
 			So(metric["metric"], ShouldEqual, "cpu.average.percent")
@@ -69,7 +86,8 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			query.Model.Set("downsampleAggregator", "sum")
 			query.Model.Set("downsampleFillPolicy", "null")
 
-			metric := exec.buildMetric(query)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
 
 			So(len(metric), ShouldEqual, 3)
 			So(metric["metric"], ShouldEqual, "cpu.average.percent")
@@ -95,7 +113,8 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			tags.Set("app", "grafana")
 			query.Model.Set("tags", tags.MustMap())
 
-			metric := exec.buildMetric(query)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
 
 			So(len(metric), ShouldEqual, 3)
 			So(metric["metric"], ShouldEqual, "cpu.average.percent")
@@ -124,7 +143,8 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			tags.Set("app", "grafana")
 			query.Model.Set("tags", tags.MustMap())
 
-			metric := exec.buildMetric(query)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
 
 			So(len(metric), ShouldEqual, 5)
 			So(metric["metric"], ShouldEqual, "cpu.average.percent")
@@ -156,7 +176,8 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			tags.Set("app", "grafana")
 			query.Model.Set("tags", tags.MustMap())
 
-			metric := exec.buildMetric(query)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
 
 			So(len(metric), ShouldEqual, 5)
 			So(metric["metric"], ShouldEqual, "cpu.average.percent")
@@ -172,5 +193,1421 @@ func TestOpenTsdbExecutor(t *testing.T) {
 			So(metric["rateOptions"].(map[string]interface{})["resetValue"], ShouldEqual, 60)
 		})
 
+		Convey("Build metric with counterMax inferred from counterBits", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "net.bytes.in")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("shouldComputeRate", true)
+			query.Model.Set("isCounter", true)
+
+			query.Model.Set("counterBits", 32)
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			rateOptions := metric["rateOptions"].(map[string]interface{})
+			So(rateOptions["counterMax"], ShouldEqual, int64(4294967295))
+			So(rateOptions["dropResets"], ShouldBeNil)
+
+			query.Model.Set("counterBits", 64)
+			metric, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["rateOptions"].(map[string]interface{})["counterMax"], ShouldEqual, int64(9223372036854775807))
+
+			query.Model.Set("counterMax", 1000)
+			metric, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["rateOptions"].(map[string]interface{})["counterMax"], ShouldEqual, 1000)
+
+			query.Model.Del("counterMax")
+			query.Model.Set("counterBits", 16)
+			_, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build metric with a point limit", func() {
+
+			query := &tsdb.Query{
+				Model:         simplejson.New(),
+				MaxDataPoints: 800,
+			}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "sum")
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			_, ok := metric["limit"]
+			So(ok, ShouldBeFalse)
+
+			query.Model.Set("autoLimit", true)
+			metric, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["limit"], ShouldEqual, 800)
+
+			query.Model.Set("limit", 100)
+			metric, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["limit"], ShouldEqual, 100)
+
+			query.Model.Set("limit", -1)
+			_, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build metric with an invalid rateZeros option", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "net.bytes.in")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("shouldComputeRate", true)
+			query.Model.Set("rateZeros", "drop")
+
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `Invalid rateZeros "drop": should be keep or gap`)
+		})
+
+		Convey("Build metric with run all downsampling", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("disableDownsampling", false)
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "sum")
+			query.Model.Set("downsampleFillPolicy", "none")
+
+			Convey("Should keep the interval without runAll", func() {
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["downsample"], ShouldEqual, "5m-sum")
+			})
+
+			Convey("Should downsample the whole range with runAll", func() {
+				dsInfo.JsonData.Set("tsdbVersion", 3)
+				query.Model.Set("runAll", true)
+
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["downsample"], ShouldEqual, "0all-sum")
+			})
+
+			Convey("Should reject runAll on older versions", func() {
+				dsInfo.JsonData.Set("tsdbVersion", 2)
+				query.Model.Set("runAll", true)
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Build metric with scalar fill policy", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("disableDownsampling", false)
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "sum")
+
+			Convey("Should reject the scalar policy", func() {
+				query.Model.Set("downsampleFillPolicy", "scalar")
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "scalar fill requires exp query type")
+			})
+
+			Convey("Should reject a scalar fill value", func() {
+				query.Model.Set("downsampleFillPolicy", "none")
+				query.Model.Set("fillScalar", 1)
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "scalar fill requires exp query type")
+			})
+		})
+
+		Convey("Build metric with client side fills", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("disableDownsampling", false)
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "sum")
+			query.Model.Set("downsampleFillPolicy", "none")
+
+			Convey("Should accept a single fill", func() {
+				query.Model.Set("carryForward", true)
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Should reject combined client side fills", func() {
+				query.Model.Set("carryForward", true)
+				query.Model.Set("fillNulls", true)
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should reject client side fills with a server fill policy", func() {
+				query.Model.Set("carryForward", true)
+				query.Model.Set("downsampleFillPolicy", "zero")
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Build metrics with multiple aggregators", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("disableDownsampling", false)
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "avg")
+			query.Model.Set("downsampleFillPolicy", "none")
+
+			Convey("Should build a single sub query by default", func() {
+				metrics, err := exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(len(metrics), ShouldEqual, 1)
+				So(metrics[0]["aggregator"], ShouldEqual, "avg")
+			})
+
+			Convey("Should build a sub query per aggregator", func() {
+				query.Model.Set("multiAggregators", []interface{}{"avg", "min", "max"})
+
+				metrics, err := exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(len(metrics), ShouldEqual, 3)
+				So(metrics[0]["aggregator"], ShouldEqual, "avg")
+				So(metrics[1]["aggregator"], ShouldEqual, "min")
+				So(metrics[2]["aggregator"], ShouldEqual, "max")
+				for _, metric := range metrics {
+					So(metric["metric"], ShouldEqual, "cpu.average.percent")
+					So(metric["downsample"], ShouldEqual, "5m-avg")
+				}
+			})
+
+			Convey("Should reject empty aggregator lists", func() {
+				query.Model.Set("multiAggregators", []interface{}{})
+
+				_, err := exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should override the downsample of aggregators", func() {
+				query.Model.Set("multiAggregators", []interface{}{"avg", "max", "min"})
+				query.Model.Set("aggregatorDownsamples", map[string]interface{}{"max": "1h-max", "min": ""})
+
+				metrics, err := exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(len(metrics), ShouldEqual, 3)
+				So(metrics[0]["downsample"], ShouldEqual, "5m-avg")
+				So(metrics[1]["downsample"], ShouldEqual, "1h-max")
+				So(metrics[2], ShouldNotContainKey, "downsample")
+
+				query.Model.Set("aggregatorDownsamples", map[string]interface{}{"sum": "1h-sum"})
+				_, err = exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+
+				query.Model.Set("aggregatorDownsamples", map[string]interface{}{"max": "1h"})
+				_, err = exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Build metrics with overlay downsamples", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "avg")
+			query.Model.Set("downsampleFillPolicy", "none")
+			query.Model.Set("multiAggregators", []interface{}{"min", "max"})
+			query.Model.Set("overlayDownsamples", []interface{}{"", "1h-avg"})
+
+			metrics, err := exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(len(metrics), ShouldEqual, 4)
+			So(metrics[0], ShouldNotContainKey, "downsample")
+			So(metrics[0]["aggregator"], ShouldEqual, "min")
+			So(metrics[1]["downsample"], ShouldEqual, "1h-avg")
+			So(metrics[1]["aggregator"], ShouldEqual, "min")
+			So(metrics[3]["downsample"], ShouldEqual, "1h-avg")
+			So(metrics[3]["aggregator"], ShouldEqual, "max")
+
+			query.Model.Set("overlayDownsamples", []interface{}{"1h"})
+			_, err = exec.buildMetrics(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build metric with unresolved template variables", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("disableDownsampling", false)
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "sum")
+			query.Model.Set("downsampleFillPolicy", "none")
+
+			Convey("Should reject an unresolved aggregator", func() {
+				query.Model.Set("aggregator", "$agg")
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "unresolved template variable $agg")
+			})
+
+			Convey("Should reject an unresolved downsample aggregator", func() {
+				query.Model.Set("downsampleAggregator", "$dsagg")
+
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "unresolved template variable $dsagg")
+			})
+		})
+
+		Convey("Build metric without aggregator", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("disableDownsampling", true)
+
+			Convey("Should reject the query by default", func() {
+				_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "no aggregator")
+			})
+
+			Convey("Should use the default aggregator of the datasource", func() {
+				dsInfo := &models.DataSource{
+					JsonData: simplejson.NewFromAny(map[string]interface{}{"defaultAggregator": "avg"}),
+				}
+
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["aggregator"], ShouldEqual, "avg")
+			})
+		})
+
+		Convey("Build metric with datasource defaults", func() {
+
+			dsInfo := &models.DataSource{
+				JsonData: simplejson.NewFromAny(map[string]interface{}{
+					"defaultAggregator":           "sum",
+					"defaultDownsampleAggregator": "max",
+				}),
+			}
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleFillPolicy", "none")
+
+			Convey("Should use the defaults when the query omits aggregators", func() {
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["aggregator"], ShouldEqual, "sum")
+				So(metric["downsample"], ShouldEqual, "5m-max")
+			})
+
+			Convey("Should prefer the aggregators of the query", func() {
+				query.Model.Set("aggregator", "avg")
+				query.Model.Set("downsampleAggregator", "min")
+
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["aggregator"], ShouldEqual, "avg")
+				So(metric["downsample"], ShouldEqual, "5m-min")
+			})
+
+			Convey("Should downsample with avg without defaults", func() {
+				metric, err := exec.buildMetric(context.Background(), &models.DataSource{
+					JsonData: simplejson.NewFromAny(map[string]interface{}{"defaultAggregator": "sum"}),
+				}, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["downsample"], ShouldEqual, "5m-avg")
+			})
+		})
+
+		Convey("Build metric with fill policy defaults", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "avg")
+
+			Convey("Should not fill without fill policies", func() {
+				query.Model.Set("downsampleFillPolicy", "")
+
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["downsample"], ShouldEqual, "5m-avg")
+			})
+
+			Convey("Should use the default fill policy of the datasource", func() {
+				dsInfo := &models.DataSource{
+					JsonData: simplejson.NewFromAny(map[string]interface{}{"defaultFillPolicy": "null"}),
+				}
+
+				metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["downsample"], ShouldEqual, "5m-avg-null")
+
+				query.Model.Set("downsampleFillPolicy", "")
+				metric, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+				So(err, ShouldBeNil)
+				So(metric["downsample"], ShouldEqual, "5m-avg-null")
+
+				Convey("Should not combine the default with client side fills", func() {
+					query.Model.Set("fillNulls", true)
+					metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+					So(err, ShouldBeNil)
+					So(metric["downsample"], ShouldEqual, "5m-avg")
+				})
+
+				Convey("Should prefer the fill policy of the query", func() {
+					query.Model.Set("downsampleFillPolicy", "zero")
+					metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+					So(err, ShouldBeNil)
+					So(metric["downsample"], ShouldEqual, "5m-avg-zero")
+
+					query.Model.Set("downsampleFillPolicy", "none")
+					metric, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+					So(err, ShouldBeNil)
+					So(metric["downsample"], ShouldEqual, "5m-avg")
+				})
+			})
+		})
+
+		Convey("Build API urls", func() {
+
+			dsInfo := &models.DataSource{
+				Url:      "http://localhost:4242",
+				JsonData: simplejson.New(),
+			}
+
+			Convey("With the default prefix", func() {
+				u, err := apiURL(dsInfo, "query")
+				So(err, ShouldBeNil)
+				So(u.String(), ShouldEqual, "http://localhost:4242/api/query")
+			})
+
+			Convey("With a custom prefix", func() {
+				dsInfo.JsonData.Set("apiPathPrefix", "tsdb/v1")
+
+				u, err := apiURL(dsInfo, "query")
+				So(err, ShouldBeNil)
+				So(u.String(), ShouldEqual, "http://localhost:4242/tsdb/v1/query")
+
+				u, err = apiURL(dsInfo, "query/gexp")
+				So(err, ShouldBeNil)
+				So(u.String(), ShouldEqual, "http://localhost:4242/tsdb/v1/query/gexp")
+
+				req, err := exec.createRequest(context.Background(), dsInfo, OpenTsdbQuery{}, nil)
+				So(err, ShouldBeNil)
+				So(req.URL.String(), ShouldEqual, "http://localhost:4242/tsdb/v1/query")
+			})
+
+			Convey("With slashes and prefixes in the base url", func() {
+				bases := []struct {
+					url      string
+					prefix   string
+					expected string
+				}{
+					{url: "http://localhost:4242", expected: "http://localhost:4242/api/"},
+					{url: "http://localhost:4242/", expected: "http://localhost:4242/api/"},
+					{url: "http://localhost:4242/api", expected: "http://localhost:4242/api/"},
+					{url: "http://localhost:4242/api/", expected: "http://localhost:4242/api/"},
+					{url: "http://localhost:4242//api//", expected: "http://localhost:4242/api/"},
+					{url: "http://proxy/opentsdb/", expected: "http://proxy/opentsdb/api/"},
+					{url: "http://proxy/opentsdb/api", expected: "http://proxy/opentsdb/api/"},
+					{url: "http://proxy/myapi", expected: "http://proxy/myapi/api/"},
+					{url: "http://proxy/tsdb/v1/", prefix: "/tsdb/v1/", expected: "http://proxy/tsdb/v1/"},
+				}
+				endpoints := []string{"query", "suggest", "search/lookup", "version"}
+
+				for _, base := range bases {
+					dsInfo.Url = base.url
+					if base.prefix != "" {
+						dsInfo.JsonData.Set("apiPathPrefix", base.prefix)
+					} else {
+						dsInfo.JsonData.Del("apiPathPrefix")
+					}
+					for _, endpoint := range endpoints {
+						u, err := apiURL(dsInfo, endpoint)
+						So(err, ShouldBeNil)
+						So(u.String(), ShouldEqual, base.expected+endpoint)
+					}
+				}
+			})
+		})
+
+		Convey("Build request never asking for deletion", func() {
+
+			dsInfo := &models.DataSource{
+				Url:      "http://localhost:4242",
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "avg")
+			query.Model.Set("disableDownsampling", true)
+			query.Model.Set("delete", true)
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			crafted := map[string]interface{}{"metric": "cpu.average.percent", "aggregator": "avg", "delete": true}
+
+			req, err := exec.createRequest(context.Background(), dsInfo, OpenTsdbQuery{Queries: []map[string]interface{}{metric, crafted}}, nil)
+			So(err, ShouldBeNil)
+
+			body, err := ioutil.ReadAll(req.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldNotContainSubstring, `"delete"`)
+		})
+
+		Convey("Build request matching the OpenTSDB request schema", func() {
+
+			dsInfo := &models.DataSource{
+				Url:      "http://localhost:4242",
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "sys.cpu.user")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "avg")
+			query.Model.Set("downsampleFillPolicy", "nan")
+			query.Model.Set("shouldComputeRate", true)
+			query.Model.Set("isCounter", true)
+			query.Model.Set("counterMax", 100)
+			query.Model.Set("counterResetValue", 1)
+			query.Model.Set("limit", 500)
+			query.Model.Set("filters", []interface{}{
+				map[string]interface{}{"type": "wildcard", "tagk": "host", "filter": "web*", "groupBy": true},
+			})
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			data := OpenTsdbQuery{
+				Start:             1546300800000,
+				End:               1546304400000,
+				Queries:           []map[string]interface{}{metric},
+				MsResolution:      true,
+				ShowQuery:         true,
+				ShowStats:         true,
+				ShowSummary:       true,
+				UseMeta:           true,
+				ShowTSUIDs:        true,
+				GlobalAnnotations: true,
+			}
+
+			req, err := exec.createRequest(context.Background(), dsInfo, data, nil)
+			So(err, ShouldBeNil)
+			body, err := ioutil.ReadAll(req.Body)
+			So(err, ShouldBeNil)
+			var sent bytes.Buffer
+			So(json.Indent(&sent, body, "", "  "), ShouldBeNil)
+
+			golden, err := ioutil.ReadFile("testdata/query_request.json")
+			So(err, ShouldBeNil)
+			So(sent.String(), ShouldEqual, strings.TrimSpace(string(golden)))
+
+			Convey("Should reject request options set on sub queries", func() {
+				metric["msResolution"] = true
+
+				_, err := exec.createRequest(context.Background(), dsInfo, data, nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Build request rejecting oversized queries", func() {
+
+			dsInfo := &models.DataSource{
+				Url:      "http://localhost:4242",
+				JsonData: simplejson.New(),
+			}
+			hosts := make([]string, 100000)
+			for i := range hosts {
+				hosts[i] = fmt.Sprintf("host-%d", i)
+			}
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{
+				"metric":     "cpu.average.percent",
+				"aggregator": "avg",
+				"tags":       map[string]interface{}{"host": strings.Join(hosts, "|")},
+			}}}
+
+			_, err := exec.createRequest(context.Background(), dsInfo, data, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "maxRequestBytes limit of 1048576 bytes")
+
+			dsInfo.JsonData.Set("maxRequestBytes", 4<<20)
+			_, err = exec.createRequest(context.Background(), dsInfo, data, nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Build request with a query timeout", func() {
+
+			dsInfo := &models.DataSource{
+				Url:      "http://localhost:4242",
+				JsonData: simplejson.New(),
+			}
+
+			Convey("Without a hint header", func() {
+				dsInfo.JsonData.Set("queryTimeout", "30s")
+
+				req, err := exec.createRequest(context.Background(), dsInfo, OpenTsdbQuery{}, nil)
+				So(err, ShouldBeNil)
+				So(req.Header.Get("X-Query-Timeout"), ShouldEqual, "")
+			})
+
+			Convey("With a hint header", func() {
+				dsInfo.JsonData.Set("queryTimeout", "30s")
+				dsInfo.JsonData.Set("queryTimeoutHeader", "X-Query-Timeout")
+
+				req, err := exec.createRequest(context.Background(), dsInfo, OpenTsdbQuery{}, nil)
+				So(err, ShouldBeNil)
+				So(req.Header.Get("X-Query-Timeout"), ShouldEqual, "30000")
+			})
+
+			Convey("With the time left to each target", func() {
+				var hints []int64
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					hint, _ := strconv.ParseInt(r.Header.Get("X-Query-Timeout"), 10, 64)
+					hints = append(hints, hint)
+					fmt.Fprint(w, "[]")
+				}))
+				defer server.Close()
+				dsInfo.Url = server.URL
+				dsInfo.JsonData.Set("queryTimeout", "30s")
+				dsInfo.JsonData.Set("queryTimeoutHeader", "X-Query-Timeout")
+
+				queries := make([]*tsdb.Query, 0, 2)
+				for _, refId := range []string{"A", "B"} {
+					query := &tsdb.Query{RefId: refId, Model: simplejson.New()}
+					query.Model.Set("metric", "cpu")
+					query.Model.Set("aggregator", "sum")
+					queries = append(queries, query)
+				}
+				_, err := exec.Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+					TimeRange: tsdb.NewTimeRange("1h", "now"),
+					Queries:   queries,
+				})
+				So(err, ShouldBeNil)
+				So(len(hints), ShouldEqual, 2)
+				So(hints[0], ShouldBeBetweenOrEqual, 14000, 15000)
+				So(hints[1], ShouldBeBetweenOrEqual, 29000, 30000)
+			})
+
+			Convey("With an invalid timeout", func() {
+				dsInfo.JsonData.Set("queryTimeout", "soon")
+				dsInfo.JsonData.Set("queryTimeoutHeader", "X-Query-Timeout")
+
+				_, err := exec.createRequest(context.Background(), dsInfo, OpenTsdbQuery{}, nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("List queried metrics for logging", func() {
+
+			data := OpenTsdbQuery{
+				Queries: []map[string]interface{}{
+					{"metric": "cpu.average.percent", "tags": map[string]interface{}{"host": "web01"}},
+					{"metric": "mem.used"},
+				},
+			}
+
+			So(queryMetrics(data), ShouldResemble, []string{"cpu.average.percent", "mem.used"})
+		})
+
+		Convey("Warn about downsample intervals finer than the data", func() {
+
+			dsInfo := &models.DataSource{
+				JsonData: simplejson.New(),
+			}
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{
+				{"metric": "cpu.average.percent", "downsample": "1s-avg"},
+				{"metric": "mem.used", "downsample": "5m-avg"},
+				{"metric": "disk.used"},
+			}}
+
+			warnings, err := downsampleWarnings(dsInfo, data)
+			So(err, ShouldBeNil)
+			So(warnings, ShouldBeEmpty)
+
+			dsInfo.JsonData.Set("minDownsampleInterval", "1m")
+			warnings, err = downsampleWarnings(dsInfo, data)
+			So(err, ShouldBeNil)
+			So(warnings, ShouldResemble, []string{
+				"Downsample interval 1s of cpu.average.percent is finer than the 1m resolution of the data. Expect sparse or repeated points",
+			})
+
+			dsInfo.JsonData.Set("minDownsampleInterval", "often")
+			_, err = downsampleWarnings(dsInfo, data)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Parse response", func() {
+
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			data := OpenTsdbQuery{
+				Start:   60000,
+				End:     300000,
+				Queries: []map[string]interface{}{{"metric": "cpu.average.percent", "downsample": "1m-avg"}},
+			}
+			response := `[{"metric": "cpu.average.percent", "dps": {"240": 3, "60": 1, "120": 2}}]`
+
+			Convey("Should sort points and convert them to milliseconds", func() {
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 1)
+
+				points := queryRes.Series[0].Points
+				So(queryRes.Series[0].Name, ShouldEqual, "cpu.average.percent")
+				So(len(points), ShouldEqual, 3)
+				So(points[0][1].Float64, ShouldEqual, 60000)
+				So(points[1][1].Float64, ShouldEqual, 120000)
+				So(points[2][1].Float64, ShouldEqual, 240000)
+			})
+
+			Convey("Should keep millisecond timestamps", func() {
+				data.MsResolution = true
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, `[{"metric": "cpu.average.percent", "dps": {"60000": 1}}]`))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Points[0][1].Float64, ShouldEqual, 60000)
+			})
+
+			Convey("Should report the first and last timestamps of series when requested", func() {
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				_, ok := queryRes.Meta.CheckGet("seriesTimes")
+				So(ok, ShouldBeFalse)
+
+				query.Model.Set("seriesTimes", true)
+				query.Model.Set("fillNulls", true)
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				points := queryRes.Series[0].Points
+				times := queryRes.Meta.Get("seriesTimes").Interface().(map[string]map[string]float64)
+				So(times["cpu.average.percent"]["first"], ShouldEqual, points[0][1].Float64)
+				So(times["cpu.average.percent"]["first"], ShouldEqual, 60000)
+				So(times["cpu.average.percent"]["last"], ShouldEqual, 240000)
+				So(points[len(points)-1][1].Float64, ShouldEqual, 300000)
+			})
+
+			Convey("Should report the timestamps of series sharing a name separately", func() {
+				query.Model.Set("seriesTimes", true)
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"host": "web-1"}, "dps": {"60": 1, "120": 2}},
+					{"metric": "cpu.average.percent", "tags": {"host": "web-2"}, "dps": {"180": 3}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Meta.Get("seriesTimes").Interface(), ShouldResemble, map[string]map[string]float64{
+					"cpu.average.percent{host=web-1}": {"first": 60000, "last": 120000},
+					"cpu.average.percent{host=web-2}": {"first": 180000, "last": 180000},
+				})
+			})
+
+			Convey("Should add boundary nulls only when requested", func() {
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series[0].Points), ShouldEqual, 3)
+
+				query.Model.Set("boundaryNulls", true)
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				points := queryRes.Series[0].Points
+				So(len(points), ShouldEqual, 4)
+				So(points[0][1].Float64, ShouldEqual, 60000)
+				So(points[0][0].Float64, ShouldEqual, 1)
+				So(points[3][1].Float64, ShouldEqual, 300000)
+				So(points[3][0].Valid, ShouldBeFalse)
+			})
+
+			Convey("Should fill missing points with nulls", func() {
+				query.Model.Set("fillNulls", true)
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+
+				points := queryRes.Series[0].Points
+				So(len(points), ShouldEqual, 5)
+				So(points[2][1].Float64, ShouldEqual, 180000)
+				So(points[2][0].Valid, ShouldBeFalse)
+				So(points[4][1].Float64, ShouldEqual, 300000)
+				So(points[4][0].Valid, ShouldBeFalse)
+			})
+
+			Convey("Should carry values forward over a sparse series", func() {
+				query.Model.Set("carryForward", true)
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+
+				points := queryRes.Series[0].Points
+				So(len(points), ShouldEqual, 5)
+				So(points[2][0].Float64, ShouldEqual, 2)
+				So(points[2][1].Float64, ShouldEqual, 180000)
+				So(points[4][0].Float64, ShouldEqual, 3)
+				So(points[4][1].Float64, ShouldEqual, 300000)
+			})
+
+			Convey("Should require downsampling to fill missing points", func() {
+				query.Model.Set("fillNulls", true)
+				data.Queries = []map[string]interface{}{{"metric": "cpu.average.percent"}}
+
+				_, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should parse non finite values as nulls", func() {
+				response := `[{"metric": "cpu.average.percent", "dps": {"60": NaN, "120": Infinity, "180": -Infinity, "240": 4}}]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+
+				points := queryRes.Series[0].Points
+				So(len(points), ShouldEqual, 4)
+				So(points[0][0].Valid, ShouldBeFalse)
+				So(points[1][0].Valid, ShouldBeFalse)
+				So(points[2][0].Valid, ShouldBeFalse)
+				So(points[3][0].Float64, ShouldEqual, 4)
+			})
+
+			Convey("Should drop all null series when requested", func() {
+				response := `[
+					{"metric": "cpu.average.percent", "dps": {"60": NaN, "120": null}},
+					{"metric": "mem.used", "dps": {"60": 1, "120": null}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 2)
+
+				query.Model.Set("dropAllNullSeries", true)
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 1)
+				So(queryRes.Series[0].Name, ShouldEqual, "mem.used")
+			})
+
+			Convey("Should warn about series aggregated without groupBy filters", func() {
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"env": "prod"}, "aggregateTags": ["host", "dc"], "dps": {"60": 1}},
+					{"metric": "cpu.average.percent", "tags": {"env": "test"}, "aggregateTags": ["host"], "dps": {"60": 1}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Meta.Get("warnings").MustArray(), ShouldResemble, []interface{}{
+					"Series are aggregated across the tags dc, host. Add a groupBy filter to see them separately",
+				})
+
+				data.Queries[0]["filters"] = []interface{}{map[string]interface{}{"tagk": "dc", "groupBy": true}}
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				_, ok := queryRes.Meta.CheckGet("warnings")
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Should treat zero rates as gaps when requested", func() {
+				data.Queries[0]["rate"] = true
+				response := `[{"metric": "cpu.average.percent", "dps": {"60": 2, "120": 0, "180": 3}}]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				points := queryRes.Series[0].Points
+				So(points[1][0].Valid, ShouldBeTrue)
+				So(points[1][0].Float64, ShouldEqual, 0)
+
+				query.Model.Set("rateZeros", "gap")
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				points = queryRes.Series[0].Points
+				So(len(points), ShouldEqual, 3)
+				So(points[0][0].Float64, ShouldEqual, 2)
+				So(points[1][0].Valid, ShouldBeFalse)
+				So(points[2][0].Float64, ShouldEqual, 3)
+			})
+
+			Convey("Should cut series off at the point limit", func() {
+				data.Queries[0]["limit"] = 2
+				response := `[{"metric": "cpu.average.percent", "dps": {"180": 3, "60": 1, "120": 2}}]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				points := queryRes.Series[0].Points
+				So(len(points), ShouldEqual, 2)
+				So(points[1][1].Float64, ShouldEqual, 120000)
+				So(len(queryRes.Meta.Get("warnings").MustArray()), ShouldEqual, 1)
+			})
+
+			Convey("Should prefix series names", func() {
+				query.Model.Set("seriesPrefix", "prod")
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Name, ShouldEqual, "prod: cpu.average.percent")
+			})
+
+			Convey("Should name series by aggregator", func() {
+				query.Model.Set("multiAggregators", []interface{}{"min", "max"})
+				data.ShowQuery = true
+				data.Queries = []map[string]interface{}{
+					{"metric": "cpu.average.percent", "aggregator": "min"},
+					{"metric": "cpu.average.percent", "aggregator": "max"},
+				}
+				response := `[
+					{"metric": "cpu.average.percent", "dps": {"60": 9}, "query": {"index": 1, "aggregator": "max"}},
+					{"metric": "cpu.average.percent", "dps": {"60": 1}, "query": {"index": 0, "aggregator": "min"}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 2)
+				So(queryRes.Series[0].Name, ShouldEqual, "cpu.average.percent (max)")
+				So(queryRes.Series[1].Name, ShouldEqual, "cpu.average.percent (min)")
+			})
+
+			Convey("Should copy the series tags", func() {
+				response := `[{"metric": "cpu.average.percent", "tags": {"env": "prod"}, "dps": {"60": 1}}]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Tags, ShouldResemble, map[string]string{"env": "prod"})
+			})
+
+			Convey("Should tag series with the aggregator when requested", func() {
+				query.Model.Set("aggregatorTag", true)
+				data.Queries = []map[string]interface{}{{"metric": "cpu.average.percent", "aggregator": "sum"}}
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Tags["_aggregator"], ShouldEqual, "sum")
+			})
+
+			Convey("Should not tag series with the aggregator by default", func() {
+				data.Queries = []map[string]interface{}{{"metric": "cpu.average.percent", "aggregator": "sum"}}
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Tags, ShouldNotContainKey, "_aggregator")
+			})
+
+			Convey("Should attach color hints when coloring by tag", func() {
+				query.Model.Set("colorByTag", "host")
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"host": "web-1"}, "dps": {"60": 1}},
+					{"metric": "cpu.average.percent", "tags": {"host": "web-2"}, "dps": {"60": 2}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Meta.Get("seriesColors").Interface(), ShouldResemble, map[string]string{
+					"cpu.average.percent{host=web-1}": seriesColor("web-1"),
+					"cpu.average.percent{host=web-2}": seriesColor("web-2"),
+				})
+			})
+
+			Convey("Should attach color hints to flattened series", func() {
+				query.Model.Set("colorByTag", "host")
+				query.Model.Set("flattenTags", true)
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"host": "web-1"}, "dps": {"60": 1}},
+					{"metric": "cpu.average.percent", "tags": {"host": "web-2"}, "dps": {"60": 2}},
+					{"metric": "cpu.average.percent", "tags": {"env": "prod"}, "dps": {"60": 3}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Tags, ShouldBeEmpty)
+				So(queryRes.Meta.Get("seriesColors").Interface(), ShouldResemble, map[string]string{
+					"cpu.average.percent{host=web-1}": seriesColor("web-1"),
+					"cpu.average.percent{host=web-2}": seriesColor("web-2"),
+				})
+			})
+
+			Convey("Should shift points by the time offset", func() {
+				query.Model.Set("timeOffset", "-1m")
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(queryRes.Series[0].Points[0][1].Float64, ShouldEqual, 0)
+			})
+
+			Convey("Should reject invalid time offsets", func() {
+				query.Model.Set("timeOffset", "25h")
+
+				_, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldNotBeNil)
+
+				query.Model.Set("timeOffset", "east")
+
+				_, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return no series for empty responses", func() {
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(204, ""))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 0)
+
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, " \n"))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 0)
+			})
+
+			Convey("Should limit the number of series", func() {
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"host": "a"}, "dps": {"60": 1}},
+					{"metric": "cpu.average.percent", "tags": {"host": "b"}, "dps": {"60": 2}},
+					{"metric": "cpu.average.percent", "tags": {"host": "c"}, "dps": {"60": 3}}
+				]`
+				dsInfo := &models.DataSource{
+					JsonData: simplejson.New(),
+				}
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				_, ok := queryRes.Meta.CheckGet("warnings")
+				So(ok, ShouldBeFalse)
+
+				dsInfo.JsonData.Set("warnSeries", 2)
+				queryRes, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 3)
+				So(queryRes.Meta.Get("warnings").MustStringArray(), ShouldResemble, []string{
+					"Query returned 3 series, more than 2. Consider narrowing it with tag filters",
+				})
+
+				dsInfo.JsonData.Set("maxSeries", 2)
+				_, err = exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should detect the resolution of each series when enabled", func() {
+				dsInfo := &models.DataSource{
+					JsonData: simplejson.NewFromAny(map[string]interface{}{"detectResolution": true}),
+				}
+				data.MsResolution = true
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"table": "raw"}, "dps": {"1577836800000": 1, "1577836860000": 2}},
+					{"metric": "cpu.average.percent", "tags": {"table": "rollup"}, "dps": {"1577836800": 3, "1577836860": 4}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 2)
+				So(queryRes.Series[0].Points[0][1].Float64, ShouldEqual, 1577836800000)
+				So(queryRes.Series[1].Points[0][1].Float64, ShouldEqual, 1577836800000)
+				So(queryRes.Series[1].Points[1][1].Float64, ShouldEqual, queryRes.Series[0].Points[1][1].Float64)
+			})
+
+			Convey("Should align series to a common grid", func() {
+				query.Model.Set("alignSeries", true)
+				data.End = 180000
+				response := `[
+					{"metric": "cpu.average.percent", "tags": {"host": "a"}, "dps": {"60": 1, "120": 2, "180": 3}},
+					{"metric": "cpu.average.percent", "tags": {"host": "b"}, "dps": {"61": 4, "179": 5}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 2)
+
+				a, b := queryRes.Series[0].Points, queryRes.Series[1].Points
+				So(len(a), ShouldEqual, 3)
+				So(len(b), ShouldEqual, 3)
+				for i := range a {
+					So(b[i][1].Float64, ShouldEqual, a[i][1].Float64)
+				}
+				So(b[0][0].Float64, ShouldEqual, 4)
+				So(b[1][1].Float64, ShouldEqual, 120000)
+				So(b[1][0].Float64, ShouldEqual, 5)
+				So(b[2][0].Valid, ShouldBeFalse)
+			})
+
+			Convey("Should name series by overlay downsample", func() {
+				query.Model.Set("overlayDownsamples", []interface{}{"", "1h-avg"})
+				data.ShowQuery = true
+				data.Queries = []map[string]interface{}{
+					{"metric": "cpu.average.percent", "aggregator": "avg"},
+					{"metric": "cpu.average.percent", "aggregator": "avg", "downsample": "1h-avg"},
+				}
+				response := `[
+					{"metric": "cpu.average.percent", "dps": {"60": 1}, "query": {"aggregator": "avg", "downsample": "1h-avg"}},
+					{"metric": "cpu.average.percent", "dps": {"60": 2}, "query": {"aggregator": "avg"}}
+				]`
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 2)
+				So(queryRes.Series[0].Name, ShouldEqual, "cpu.average.percent (1h-avg)")
+				So(queryRes.Series[1].Name, ShouldEqual, "cpu.average.percent (raw)")
+			})
+
+			Convey("Should attach response headers when debugging", func() {
+				res := newResponse(200, response)
+				res.Header.Set("X-TSDB-Version", "2.4.0")
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, res)
+				So(err, ShouldBeNil)
+				_, ok := queryRes.Meta.CheckGet("responseHeaders")
+				So(ok, ShouldBeFalse)
+
+				query.Model.Set("debugHeaders", true)
+				res = newResponse(200, response)
+				res.Header.Set("X-TSDB-Version", "2.4.0")
+
+				queryRes, err = exec.parseResponse(dsInfo, query, data, res)
+				So(err, ShouldBeNil)
+				So(queryRes.Meta.Get("responseHeaders").Interface(), ShouldResemble, map[string]string{"X-Tsdb-Version": "2.4.0"})
+			})
+
+			Convey("Should match sub queries by aggregator without index", func() {
+				data.Queries = []map[string]interface{}{
+					{"metric": "cpu.average.percent", "aggregator": "min"},
+					{"metric": "cpu.average.percent", "aggregator": "max"},
+				}
+
+				index := subQueryIndex(OpenTsdbResponse{Query: &OpenTsdbSubQuery{Aggregator: "max"}}, data)
+				So(index, ShouldEqual, 1)
+			})
+
+			Convey("Should attach the raw response when requested", func() {
+				query.Model.Set("rawResponse", true)
+
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+				So(len(queryRes.Series), ShouldEqual, 1)
+
+				raw := queryRes.Meta.Get("rawResponse").GetIndex(0)
+				So(raw.Get("metric").MustString(), ShouldEqual, "cpu.average.percent")
+				So(raw.Get("dps").Get("120").MustFloat64(), ShouldEqual, 2)
+			})
+
+			Convey("Should not attach the raw response by default", func() {
+				queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+				So(err, ShouldBeNil)
+
+				_, ok := queryRes.Meta.CheckGet("rawResponse")
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Should fail on error status", func() {
+				_, err := exec.parseResponse(dsInfo, query, data, newResponse(500, `{"error": {"code": 500}}`))
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Query each target separately", func() {
+
+			var requests []OpenTsdbQuery
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var data OpenTsdbQuery
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				requests = append(requests, data)
+				fmt.Fprintf(w, `[{"metric": %q, "dps": {"60": 1}}]`, data.Queries[0]["metric"])
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			queryA := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			queryA.Model.Set("metric", "cpu.average.percent")
+			queryA.Model.Set("aggregator", "avg")
+			queryB := &tsdb.Query{RefId: "B", Model: simplejson.New()}
+			queryB.Model.Set("metric", "mem.used")
+			queryB.Model.Set("aggregator", "sum")
+			queryB.Model.Set("multiAggregators", []interface{}{"min", "max"})
+
+			resp, err := exec.Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1546300800000", "1546304400000"),
+				Queries:   []*tsdb.Query{queryA, queryB},
+			})
+			So(err, ShouldBeNil)
+			So(len(requests), ShouldEqual, 2)
+			So(len(requests[0].Queries), ShouldEqual, 1)
+			So(requests[0].ShowQuery, ShouldBeFalse)
+			So(len(requests[1].Queries), ShouldEqual, 2)
+			So(requests[1].ShowQuery, ShouldBeTrue)
+			So(len(resp.Results), ShouldEqual, 2)
+			So(resp.Results["A"].RefId, ShouldEqual, "A")
+			So(resp.Results["A"].Series[0].Name, ShouldEqual, "cpu.average.percent")
+			So(resp.Results["B"].Series[0].Name, ShouldEqual, "mem.used (min)")
+			So(resp.Results["A"].Meta.Get("seriesCount").MustInt(), ShouldEqual, len(resp.Results["A"].Series))
+			So(resp.Results["B"].Meta.Get("seriesCount").MustInt(), ShouldEqual, len(resp.Results["B"].Series))
+			So(resp.Results["A"].Meta.Get("downsample").MustString(), ShouldEqual, "1m-avg")
+			So(resp.Results["A"].Meta.Get("downsample").MustString(), ShouldEqual, requests[0].Queries[0]["downsample"])
+		})
+
+		Convey("Send sub queries with mixed downsamples in one request", func() {
+
+			var requests []OpenTsdbQuery
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var data OpenTsdbQuery
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				requests = append(requests, data)
+				fmt.Fprint(w, `[
+					{"metric": "mem.used", "query": {"aggregator": "avg", "downsample": "5m-avg"}, "dps": {"300": 1}},
+					{"metric": "mem.used", "query": {"aggregator": "max", "downsample": "1h-max"}, "dps": {"3600": 2}}
+				]`)
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			query.Model.Set("metric", "mem.used")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "avg")
+			query.Model.Set("multiAggregators", []interface{}{"avg", "max"})
+			query.Model.Set("aggregatorDownsamples", map[string]interface{}{"max": "1h-max"})
+
+			resp, err := exec.Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1546300800000", "1546304400000"),
+				Queries:   []*tsdb.Query{query},
+			})
+			So(err, ShouldBeNil)
+			So(len(requests), ShouldEqual, 1)
+			So(len(requests[0].Queries), ShouldEqual, 2)
+			So(requests[0].Queries[0]["downsample"], ShouldEqual, "5m-avg")
+			So(requests[0].Queries[1]["downsample"], ShouldEqual, "1h-max")
+			series := resp.Results["A"].Series
+			So(len(series), ShouldEqual, 2)
+			So(series[0].Name, ShouldEqual, "mem.used (avg)")
+			So(series[1].Name, ShouldEqual, "mem.used (max)")
+			So(resp.Results["A"].Meta.Get("seriesCount").MustInt(), ShouldEqual, 2)
+			So(resp.Results["A"].Meta.Get("downsample").MustString(), ShouldBeEmpty)
+		})
+
+		Convey("Round time ranges to whole seconds", func() {
+			start, end := roundToSeconds(1546300800123, 1546304400456)
+			So(start, ShouldEqual, 1546300800000)
+			So(end, ShouldEqual, 1546304401000)
+
+			start, end = roundToSeconds(1546300800000, 1546304400000)
+			So(start, ShouldEqual, 1546300800000)
+			So(end, ShouldEqual, 1546304400000)
+		})
+
+		Convey("Report the downsample sent", func() {
+			downsample, ok := sentDownsample(OpenTsdbQuery{Queries: []map[string]interface{}{
+				{"downsample": "5m-max-nan"},
+				{"downsample": "5m-max-nan"},
+			}})
+			So(ok, ShouldBeTrue)
+			So(downsample, ShouldEqual, "5m-max-nan")
+
+			downsample, ok = sentDownsample(OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu"}}})
+			So(ok, ShouldBeTrue)
+			So(downsample, ShouldEqual, "")
+
+			_, ok = sentDownsample(OpenTsdbQuery{Queries: []map[string]interface{}{
+				{"downsample": "5m-max"},
+				{"metric": "cpu"},
+			}})
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Fall back to full scans when meta queries return nothing", func() {
+
+			var requests []OpenTsdbQuery
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var data OpenTsdbQuery
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				requests = append(requests, data)
+				if data.UseMeta {
+					fmt.Fprint(w, "[]")
+					return
+				}
+				fmt.Fprint(w, `[{"metric": "cpu", "dps": {"60": 1}}]`)
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("useMeta", true)
+			queryContext := &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1546300800000", "1546304400000"),
+				Queries:   []*tsdb.Query{query},
+			}
+
+			Convey("Should retry without useMeta when enabled", func() {
+				query.Model.Set("useMetaFallback", true)
+
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				So(len(requests), ShouldEqual, 2)
+				So(requests[0].UseMeta, ShouldBeTrue)
+				So(requests[1].UseMeta, ShouldBeFalse)
+				So(len(resp.Results["A"].Series), ShouldEqual, 1)
+			})
+
+			Convey("Should keep empty meta results by default", func() {
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				So(len(requests), ShouldEqual, 1)
+				So(len(resp.Results["A"].Series), ShouldEqual, 0)
+			})
+		})
+
+		Convey("Name the datasource when its HTTP client can't be built", func() {
+			setting.SecretKey = "password"
+			caCert, err := util.Encrypt([]byte("not a certificate"), "password")
+			So(err, ShouldBeNil)
+
+			dsInfo := &models.DataSource{
+				Id:             165,
+				Name:           "tsdb-eu",
+				Url:            "http://localhost:4242",
+				JsonData:       simplejson.NewFromAny(map[string]interface{}{"tlsAuthWithCACert": true}),
+				SecureJsonData: map[string][]byte{"tlsCACert": caCert},
+			}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+
+			_, err = exec.Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1546300800000", "1546304400000"),
+				Queries:   []*tsdb.Query{query},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "failed to build HTTP client for OpenTSDB datasource 'tsdb-eu': ")
+		})
+
+		Convey("Signal queries matching no series when configured", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "[]")
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "sum")
+			queryContext := &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("1546300800000", "1546304400000"),
+				Queries:   []*tsdb.Query{query},
+			}
+
+			Convey("Should return an empty result by default", func() {
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				_, ok := resp.Results["A"].Meta.CheckGet("noData")
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Should mark the result when the datasource asks for it", func() {
+				dsInfo.JsonData.Set("emptyResult", "noData")
+
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				So(resp.Results["A"].Meta.Get("noData").MustBool(), ShouldBeTrue)
+			})
+
+			Convey("Should let queries override the datasource", func() {
+				dsInfo.JsonData.Set("emptyResult", "noData")
+				query.Model.Set("emptyResult", "empty")
+
+				resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldBeNil)
+				_, ok := resp.Results["A"].Meta.CheckGet("noData")
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Should reject unknown modes", func() {
+				query.Model.Set("emptyResult", "fail")
+
+				_, err := exec.Query(context.Background(), dsInfo, queryContext)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Check datasource is writable", func() {
+
+			dsInfo := &models.DataSource{
+				JsonData: simplejson.New(),
+			}
+
+			So(checkWritable(dsInfo), ShouldBeNil)
+
+			dsInfo.JsonData.Set("readOnly", true)
+			So(checkWritable(dsInfo), ShouldEqual, errReadOnly)
+		})
+
 	})
 }
+
+func newResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
@@ -0,0 +1,117 @@
+package opentsdb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// OpenTSDB names the series of histogram percentile queries after the
+// metric, suffixed with the percentile, e.g. "latency_pct_99.9".
+var percentileMetricPattern = regexp.MustCompile(`^(.+)_pct_(\d+(?:\.\d+)?)$`)
+
+// percentileAggregatorPattern matches percentile aggregators: exact ones like
+// "p99" and estimated ones like "ep99r3".
+var percentileAggregatorPattern = regexp.MustCompile(`^(e?)p(\d+)(r[37])?$`)
+
+// percentileAggregators are the percentiles OpenTSDB has aggregators for.
+var percentileAggregators = map[string]bool{"50": true, "75": true, "90": true, "95": true, "99": true, "999": true}
+
+// percentileAggregator validates percentile aggregators and applies the
+// query's percentileEstimation, r3 or r7, which picks the estimating variant
+// of exact percentile aggregators. Other aggregators are returned unchanged.
+func percentileAggregator(aggregator string, estimation string) (string, error) {
+	match := percentileAggregatorPattern.FindStringSubmatch(aggregator)
+	if match == nil {
+		return aggregator, nil
+	}
+
+	if !percentileAggregators[match[2]] || (match[1] == "e") != (match[3] != "") {
+		return "", fmt.Errorf("Invalid percentile aggregator %s: should be one of p50, p75, p90, p95, p99 or p999, "+
+			"or their ep..r3 and ep..r7 estimations", aggregator)
+	}
+
+	switch estimation {
+	case "":
+		return aggregator, nil
+	case "r3", "r7":
+		return "ep" + match[2] + estimation, nil
+	default:
+		return "", fmt.Errorf("Invalid percentileEstimation %q: should be r3 or r7", estimation)
+	}
+}
+
+// parsePercentiles reads the percentiles requested by a query.
+func parsePercentiles(model *simplejson.Json) ([]float64, error) {
+	values := model.Get("percentiles").MustArray()
+	percentiles := make([]float64, 0, len(values))
+
+	for i := range values {
+		percentile, err := model.Get("percentiles").GetIndex(i).Float64()
+		if err != nil || percentile <= 0 || percentile > 100 {
+			return nil, fmt.Errorf("Invalid percentile %v: should be a number between 0 and 100", values[i])
+		}
+		percentiles = append(percentiles, percentile)
+	}
+
+	return percentiles, nil
+}
+
+// parsePercentileMetric splits the metric name of a percentile series into
+// the queried metric and the percentile.
+func parsePercentileMetric(name string) (string, float64, bool) {
+	match := percentileMetricPattern.FindStringSubmatch(name)
+	if match == nil {
+		return name, 0, false
+	}
+
+	percentile, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return name, 0, false
+	}
+
+	return match[1], percentile, true
+}
+
+// responsePercentile returns the queried metric and the percentile of a
+// percentile series. The percentile field of the response is preferred over
+// the suffix of the metric name, which is only parsed when it's missing.
+func responsePercentile(response OpenTsdbResponse) (string, float64, bool) {
+	metric, percentile, ok := parsePercentileMetric(response.Metric)
+	if response.Percentile == nil {
+		return metric, percentile, ok
+	}
+	return metric, *response.Percentile, true
+}
+
+// percentileSeriesName names a percentile series like "latency p99.9".
+func percentileSeriesName(metric string, percentile float64) string {
+	return metric + " p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+}
+
+type percentileSeries struct {
+	series     *tsdb.TimeSeries
+	metric     string
+	percentile float64
+}
+
+// sortPercentileSeries orders percentile series by metric, then ascending
+// percentile, so that overlaid percentile graphs are stable across refreshes.
+func sortPercentileSeries(series []percentileSeries) tsdb.TimeSeriesSlice {
+	sort.SliceStable(series, func(i, j int) bool {
+		if series[i].metric != series[j].metric {
+			return series[i].metric < series[j].metric
+		}
+		return series[i].percentile < series[j].percentile
+	})
+
+	sorted := make(tsdb.TimeSeriesSlice, 0, len(series))
+	for _, s := range series {
+		sorted = append(sorted, s.series)
+	}
+	return sorted
+}
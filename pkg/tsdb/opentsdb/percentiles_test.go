@@ -0,0 +1,164 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbPercentiles(t *testing.T) {
+	Convey("OpenTsdb percentiles testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{
+			JsonData: simplejson.New(),
+		}
+
+		Convey("Build metric with percentiles", func() {
+			server := newVersionServer("2.4.0")
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "latency")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("disableDownsampling", true)
+			query.Model.Set("percentiles", []interface{}{99.9, 50})
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["percentiles"], ShouldResemble, []float64{99.9, 50})
+
+			query.Model.Set("percentiles", []interface{}{101})
+			_, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Reject percentiles before OpenTSDB 2.4", func() {
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "latency")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("disableDownsampling", true)
+			query.Model.Set("percentiles", []interface{}{99})
+
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "histogram percentiles requires OpenTSDB >= 2.4")
+		})
+
+		Convey("Validate percentile aggregators", func() {
+			aggregator, err := percentileAggregator("p999", "")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "p999")
+
+			aggregator, err = percentileAggregator("ep75r7", "")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "ep75r7")
+
+			aggregator, err = percentileAggregator("sum", "r3")
+			So(err, ShouldBeNil)
+			So(aggregator, ShouldEqual, "sum")
+
+			_, err = percentileAggregator("p101", "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "Invalid percentile aggregator p101")
+
+			_, err = percentileAggregator("ep99", "")
+			So(err, ShouldNotBeNil)
+
+			_, err = percentileAggregator("p99", "r5")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build metric with estimated percentile aggregators", func() {
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "latency")
+			query.Model.Set("aggregator", "p99")
+			query.Model.Set("downsampleInterval", "5m")
+			query.Model.Set("downsampleAggregator", "p95")
+			query.Model.Set("percentileEstimation", "r3")
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["aggregator"], ShouldEqual, "ep99r3")
+			So(metric["downsample"], ShouldEqual, "5m-ep95r3")
+
+			query.Model.Set("aggregator", "p101")
+			_, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Parse percentile metric names", func() {
+			metric, percentile, ok := parsePercentileMetric("latency_pct_99.9")
+			So(ok, ShouldBeTrue)
+			So(metric, ShouldEqual, "latency")
+			So(percentile, ShouldEqual, 99.9)
+
+			_, _, ok = parsePercentileMetric("latency")
+			So(ok, ShouldBeFalse)
+
+			So(percentileSeriesName("latency", 99), ShouldEqual, "latency p99")
+			So(percentileSeriesName("latency", 99.9), ShouldEqual, "latency p99.9")
+		})
+
+		Convey("Parse out of order percentile responses", func() {
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			data := OpenTsdbQuery{
+				Start:   60000,
+				End:     120000,
+				Queries: []map[string]interface{}{{"metric": "latency", "percentiles": []float64{50, 99, 99.9}}},
+			}
+			response := `[
+				{"metric": "latency_pct_99.9", "dps": {"60": 3}},
+				{"metric": "latency_pct_50.0", "dps": {"60": 1}},
+				{"metric": "latency_pct_99.0", "dps": {"60": 2}}
+			]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(len(queryRes.Series), ShouldEqual, 3)
+			So(queryRes.Series[0].Name, ShouldEqual, "latency p50")
+			So(queryRes.Series[1].Name, ShouldEqual, "latency p99")
+			So(queryRes.Series[2].Name, ShouldEqual, "latency p99.9")
+		})
+
+		Convey("Parse explicit percentile fields", func() {
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			data := OpenTsdbQuery{
+				Start:   60000,
+				End:     120000,
+				Queries: []map[string]interface{}{{"metric": "latency", "percentiles": []float64{50, 99, 99.9}}},
+			}
+			response := `[
+				{"metric": "latency", "percentile": 99, "dps": {"60": 2}},
+				{"metric": "latency", "percentile": 99.9, "dps": {"60": 3}},
+				{"metric": "latency_pct_99.0", "percentile": 50, "dps": {"60": 1}}
+			]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(len(queryRes.Series), ShouldEqual, 3)
+			So(queryRes.Series[0].Name, ShouldEqual, "latency p50")
+			So(queryRes.Series[0].Points[0][0].Float64, ShouldEqual, 1)
+			So(queryRes.Series[1].Name, ShouldEqual, "latency p99")
+			So(queryRes.Series[2].Name, ShouldEqual, "latency p99.9")
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+const (
+	// prewarmTimeout bounds the suggest request filling the metric cache.
+	prewarmTimeout = 30 * time.Second
+
+	// metricCacheTTL is how long prewarmed metric names are used before they
+	// are fetched again, so that new metrics show up in wildcard expansions.
+	metricCacheTTL = 10 * time.Minute
+)
+
+type cachedMetricList struct {
+	datasourceId int64
+	names        []string
+	truncated    bool
+	expires      time.Time
+	refreshing   bool
+}
+
+// metricCache holds the metric names of datasources with prewarmMetrics set,
+// keyed by datasourceKey.
+var metricCache = struct {
+	sync.Mutex
+	entries map[string]cachedMetricList
+}{entries: make(map[string]cachedMetricList)}
+
+// startPrewarm fills the metric cache of dsInfo in the background when an
+// executor is created for it and its cached names are missing or expired.
+func (e *OpenTsdbExecutor) startPrewarm(dsInfo *models.DataSource) {
+	if !dsInfo.JsonData.Get("prewarmMetrics").MustBool() {
+		return
+	}
+
+	key := datasourceKey(dsInfo)
+	metricCache.Lock()
+	entry, ok := metricCache.entries[key]
+	start := !entry.refreshing && (!ok || time.Now().After(entry.expires))
+	if start {
+		entry.datasourceId = dsInfo.Id
+		entry.refreshing = true
+		metricCache.entries[key] = entry
+	}
+	metricCache.Unlock()
+	if !start {
+		return
+	}
+
+	go func() {
+		httpClient, err := dsInfo.GetHttpClient()
+		if err == nil {
+			err = e.prewarmMetrics(context.Background(), dsInfo, httpClient)
+		}
+		if err != nil {
+			plog.Info("Failed to prewarm opentsdb metrics", "error", err)
+			metricCache.Lock()
+			delete(metricCache.entries, key)
+			metricCache.Unlock()
+		}
+	}()
+}
+
+// prewarmMetrics stores up to lookupMaxResults metric names of dsInfo in the
+// metric cache, evicting expired entries and those of earlier versions of
+// the datasource's settings.
+func (e *OpenTsdbExecutor) prewarmMetrics(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+	defer cancel()
+
+	max := dsInfo.JsonData.Get("lookupMaxResults").MustInt(10000)
+	names, err := e.suggestMetrics(ctx, dsInfo, httpClient, nil, "", max)
+	if err != nil {
+		return err
+	}
+
+	key := datasourceKey(dsInfo)
+	now := time.Now()
+
+	metricCache.Lock()
+	defer metricCache.Unlock()
+	for k, entry := range metricCache.entries {
+		if k != key && (entry.datasourceId == dsInfo.Id || (now.After(entry.expires) && !entry.refreshing)) {
+			delete(metricCache.entries, k)
+		}
+	}
+	metricCache.entries[key] = cachedMetricList{
+		datasourceId: dsInfo.Id,
+		names:        names,
+		truncated:    len(names) >= max,
+		expires:      now.Add(metricCacheTTL),
+	}
+	return nil
+}
+
+// cachedMetrics lists the metric names starting with prefix from the metric
+// cache, asking the suggest endpoint when the cache isn't filled, has expired
+// or holds only part of the metrics. Queries with their own headers, which
+// may select another tenant, always ask.
+func (e *OpenTsdbExecutor) cachedMetrics(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header, prefix string) ([]string, error) {
+	metricCache.Lock()
+	entry, ok := metricCache.entries[datasourceKey(dsInfo)]
+	metricCache.Unlock()
+
+	if !ok || entry.truncated || time.Now().After(entry.expires) || len(headers) > 0 {
+		return e.suggestMetrics(ctx, dsInfo, httpClient, headers, prefix, dsInfo.JsonData.Get("lookupLimit").MustInt(1000))
+	}
+
+	var names []string
+	for _, name := range entry.names {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,115 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbPrewarm(t *testing.T) {
+	Convey("OpenTsdb prewarm testing", t, func() {
+
+		var requestURLs []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURLs = append(requestURLs, r.URL.String())
+			fmt.Fprint(w, `["net.bytes.in", "sys.cpu.system", "sys.cpu.user"]`)
+		}))
+		defer server.Close()
+
+		dsInfo := &models.DataSource{
+			Id:       170,
+			Url:      server.URL,
+			JsonData: simplejson.New(),
+			Updated:  time.Now(),
+		}
+		exec := &OpenTsdbExecutor{}
+
+		Convey("Should not prewarm by default", func() {
+			exec.startPrewarm(dsInfo)
+
+			metricCache.Lock()
+			_, ok := metricCache.entries[datasourceKey(dsInfo)]
+			metricCache.Unlock()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should expand wildcards from the prewarmed cache", func() {
+			So(exec.prewarmMetrics(context.Background(), dsInfo, server.Client()), ShouldBeNil)
+			So(requestURLs, ShouldResemble, []string{"/api/suggest?max=10000&q=&type=metrics"})
+
+			query := &tsdb.Query{Model: simplejson.New()}
+			expanded, err := exec.expandMetrics(context.Background(), dsInfo, server.Client(), query,
+				[]map[string]interface{}{{"metric": "sys.cpu.*", "aggregator": "sum"}})
+			So(err, ShouldBeNil)
+			So(len(requestURLs), ShouldEqual, 1)
+			So(expanded, ShouldResemble, []map[string]interface{}{
+				{"metric": "sys.cpu.system", "aggregator": "sum"},
+				{"metric": "sys.cpu.user", "aggregator": "sum"},
+			})
+		})
+
+		Convey("Should ask the suggest endpoint once the cache expired", func() {
+			So(exec.prewarmMetrics(context.Background(), dsInfo, server.Client()), ShouldBeNil)
+			metricCache.Lock()
+			entry := metricCache.entries[datasourceKey(dsInfo)]
+			entry.expires = time.Now().Add(-time.Second)
+			metricCache.entries[datasourceKey(dsInfo)] = entry
+			metricCache.Unlock()
+
+			names, err := exec.cachedMetrics(context.Background(), dsInfo, server.Client(), nil, "sys.cpu.")
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"net.bytes.in", "sys.cpu.system", "sys.cpu.user"})
+			So(requestURLs, ShouldResemble, []string{
+				"/api/suggest?max=10000&q=&type=metrics",
+				"/api/suggest?max=1000&q=sys.cpu.&type=metrics",
+			})
+		})
+
+		Convey("Should ask the suggest endpoint when the cache holds only part of the metrics", func() {
+			dsInfo.JsonData.Set("lookupMaxResults", 3)
+			So(exec.prewarmMetrics(context.Background(), dsInfo, server.Client()), ShouldBeNil)
+
+			_, err := exec.cachedMetrics(context.Background(), dsInfo, server.Client(), nil, "sys.cpu.")
+			So(err, ShouldBeNil)
+			So(len(requestURLs), ShouldEqual, 2)
+		})
+
+		Convey("Should evict the metrics of earlier datasource settings", func() {
+			So(exec.prewarmMetrics(context.Background(), dsInfo, server.Client()), ShouldBeNil)
+			earlier := datasourceKey(dsInfo)
+			dsInfo.Updated = dsInfo.Updated.Add(time.Second)
+			So(exec.prewarmMetrics(context.Background(), dsInfo, server.Client()), ShouldBeNil)
+
+			metricCache.Lock()
+			_, ok := metricCache.entries[earlier]
+			metricCache.Unlock()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should prewarm when creating an executor for a saved datasource", func() {
+			dsInfo.JsonData.Set("prewarmMetrics", true)
+			dsInfo.Updated = dsInfo.Updated.Add(time.Second)
+
+			_, err := NewOpenTsdbExecutor(dsInfo)
+			So(err, ShouldBeNil)
+
+			var cached []string
+			deadline := time.Now().Add(5 * time.Second)
+			for cached == nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				metricCache.Lock()
+				cached = metricCache.entries[datasourceKey(dsInfo)].names
+				metricCache.Unlock()
+			}
+			So(cached, ShouldResemble, []string{"net.bytes.in", "sys.cpu.system", "sys.cpu.user"})
+		})
+	})
+}
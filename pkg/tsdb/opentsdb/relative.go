@@ -0,0 +1,61 @@
+package opentsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// grafanaRelativePattern matches the relative times of Grafana time ranges
+// that OpenTSDB can express, such as "now-1h". Rounding isn't supported.
+var grafanaRelativePattern = regexp.MustCompile(`^now-([1-9]\d*)(s|m|h|d|w|M|y)$`)
+
+// relativeTimeRange converts a Grafana time range like now-1h to now into
+// OpenTSDB relative times, leaving end empty for ranges ending now. OpenTSDB
+// then resolves them against its own clock.
+func relativeTimeRange(timeRange *tsdb.TimeRange) (string, string, error) {
+	start, err := relativeTime(timeRange.From)
+	if err != nil {
+		return "", "", err
+	}
+	if timeRange.To == "now" {
+		return start, "", nil
+	}
+	end, err := relativeTime(timeRange.To)
+	if err != nil {
+		return "", "", err
+	}
+	return start, end, nil
+}
+
+func relativeTime(value string) (string, error) {
+	match := grafanaRelativePattern.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("Invalid relative time %q: should be like now-1h", value)
+	}
+
+	// OpenTSDB calls months n.
+	unit := match[2]
+	if unit == "M" {
+		unit = "n"
+	}
+	return match[1] + unit + "-ago", nil
+}
+
+// MarshalJSON sends the relative start and end of the query in place of the
+// absolute ones when they are set.
+func (q OpenTsdbQuery) MarshalJSON() ([]byte, error) {
+	type query OpenTsdbQuery
+	if q.RelativeStart == "" {
+		return json.Marshal(query(q))
+	}
+
+	relative := struct {
+		query
+		Start string `json:"start"`
+		End   string `json:"end,omitempty"`
+	}{query: query(q), Start: q.RelativeStart, End: q.RelativeEnd}
+	return json.Marshal(relative)
+}
@@ -0,0 +1,86 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbRelative(t *testing.T) {
+	Convey("OpenTsdb relative time testing", t, func() {
+
+		Convey("Convert Grafana relative times", func() {
+			start, end, err := relativeTimeRange(tsdb.NewTimeRange("now-1h", "now"))
+			So(err, ShouldBeNil)
+			So(start, ShouldEqual, "1h-ago")
+			So(end, ShouldEqual, "")
+
+			start, end, err = relativeTimeRange(tsdb.NewTimeRange("now-30m", "now-5m"))
+			So(err, ShouldBeNil)
+			So(start, ShouldEqual, "30m-ago")
+			So(end, ShouldEqual, "5m-ago")
+
+			start, _, err = relativeTimeRange(tsdb.NewTimeRange("now-6M", "now"))
+			So(err, ShouldBeNil)
+			So(start, ShouldEqual, "6n-ago")
+		})
+
+		Convey("Reject times OpenTSDB can't express", func() {
+			for _, from := range []string{"now/d", "now-1d/d", "now-0h", "1546300800000", "1h-ago"} {
+				_, _, err := relativeTimeRange(tsdb.NewTimeRange(from, "now"))
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Marshal relative times in place of absolute ones", func() {
+			data := OpenTsdbQuery{Start: 60000, End: 120000, MsResolution: true}
+			body, err := json.Marshal(data)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, `{"start":60000,"end":120000,"queries":null,"msResolution":true}`)
+
+			data.RelativeStart = "1h-ago"
+			body, err = json.Marshal(data)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, `{"queries":null,"msResolution":true,"start":"1h-ago"}`)
+		})
+
+		Convey("Send relative time ranges when requested", func() {
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				_, _ = w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+			query.Model.Set("metric", "cpu")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("relativeTime", true)
+
+			exec := &OpenTsdbExecutor{}
+			_, err := exec.Query(context.Background(), dsInfo, &tsdb.TsdbQuery{
+				TimeRange: tsdb.NewTimeRange("now-30m", "now"),
+				Queries:   []*tsdb.Query{query},
+			})
+			So(err, ShouldBeNil)
+
+			var sent map[string]interface{}
+			So(json.Unmarshal(body, &sent), ShouldBeNil)
+			So(sent["start"], ShouldEqual, "30m-ago")
+			_, ok := sent["end"]
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,230 @@
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+
+	"github.com/grafana/grafana/pkg/components/null"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// sensitiveHeaderWords mark the headers debugHeaders leaves out, since they
+// may carry credentials.
+var sensitiveHeaderWords = []string{"auth", "cookie", "token", "secret", "key", "session"}
+
+// msTimestampThreshold separates timestamps in milliseconds from timestamps in
+// seconds. As milliseconds it's in 1973, as seconds in the year 5138.
+const msTimestampThreshold = 1e11
+
+// responseFields are the response fields whose names can be remapped with the
+// datasource's fieldNames setting.
+var responseFields = []string{"metric", "dps", "tags", "aggregateTags"}
+
+var nonFiniteTokens = [][]byte{[]byte("-Infinity"), []byte("Infinity"), []byte("NaN")}
+
+// replaceNonFiniteValues replaces the bare NaN, Infinity and -Infinity tokens
+// some OpenTSDB aggregators emit, which aren't valid JSON, with null. Tokens
+// inside strings, such as tag values, are left untouched.
+func replaceNonFiniteValues(body []byte) []byte {
+	var out []byte
+	inString := false
+	last := 0
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		if c == '"' {
+			inString = true
+			continue
+		}
+
+		for _, token := range nonFiniteTokens {
+			if bytes.HasPrefix(body[i:], token) {
+				if out == nil {
+					out = make([]byte, 0, len(body))
+				}
+				out = append(out, body[last:i]...)
+				out = append(out, "null"...)
+				i += len(token) - 1
+				last = i + 1
+				break
+			}
+		}
+	}
+
+	if out == nil {
+		return body
+	}
+	return append(out, body[last:]...)
+}
+
+// responseFieldNames returns the alternate names, keyed by standard OpenTSDB
+// field name, that the datasource's backend uses in query responses.
+func responseFieldNames(dsInfo *models.DataSource) map[string]string {
+	fieldNames := make(map[string]string)
+	for _, field := range responseFields {
+		name := dsInfo.JsonData.Get("fieldNames").Get(field).MustString()
+		if name != "" && name != field {
+			fieldNames[field] = name
+		}
+	}
+	return fieldNames
+}
+
+// unmarshalResponses decodes a query response, renaming the fields of
+// near-compatible backends to their standard OpenTSDB names first.
+func unmarshalResponses(body []byte, fieldNames map[string]string) ([]OpenTsdbResponse, error) {
+	body, err := unwrapResults(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []OpenTsdbResponse
+	if len(fieldNames) == 0 {
+		err = json.Unmarshal(body, &responses)
+		return responses, err
+	}
+
+	var elements []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, err
+	}
+
+	responses = make([]OpenTsdbResponse, len(elements))
+	for i, element := range elements {
+		for field, name := range fieldNames {
+			value, ok := element[name]
+			if !ok {
+				continue
+			}
+			delete(element, name)
+			element[field] = value
+		}
+
+		renamed, err := json.Marshal(element)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(renamed, &responses[i]); err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// isMsResolution reports whether the timestamps of dps are in milliseconds,
+// judging by their magnitude. Series read from rollup and raw tables can come
+// back with different resolutions in the same response.
+func isMsResolution(dps map[string]OpenTsdbValue) bool {
+	for timeString := range dps {
+		if timestamp, err := strconv.ParseFloat(timeString, 64); err == nil && timestamp >= msTimestampThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON parses numbers, null and numbers encoded as strings. Non
+// finite values become null, like their bare tokens do.
+func (v *OpenTsdbValue) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '"' {
+		return v.Float.UnmarshalJSON(data)
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("Invalid data point value %q: should be a number", s)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		v.Float = null.FloatFromPtr(nil)
+		return nil
+	}
+	v.Float = null.FloatFrom(value)
+	return nil
+}
+
+// debugHeaders returns the response headers, joined per name, without the
+// ones that may carry credentials.
+func debugHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if isSensitiveHeader(name) {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeCharset transcodes body to UTF-8 from the charset declared by
+// contentType, for gateways transcoding responses. Bodies without a declared
+// charset are taken to be UTF-8 already.
+func decodeCharset(body []byte, contentType string) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return body, nil
+	}
+
+	label := strings.ToLower(strings.TrimSpace(params["charset"]))
+	if label == "" || label == "utf-8" || label == "utf8" {
+		return body, nil
+	}
+
+	reader, err := charset.NewReaderLabel(label, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported response charset %q", label)
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// unwrapResults returns the results of responses wrapped in an object like
+// {"results": [...]}, as some API gateways do, and other responses unchanged.
+func unwrapResults(body []byte) ([]byte, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) == 0 || trimmed[0] != '{' {
+		return body, nil
+	}
+
+	var wrapped struct {
+		Results json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, err
+	}
+	if wrapped.Results == nil {
+		return nil, fmt.Errorf("Response object has no results")
+	}
+	return wrapped.Results, nil
+}
@@ -0,0 +1,134 @@
+package opentsdb
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbResponse(t *testing.T) {
+	Convey("OpenTsdb response testing", t, func() {
+
+		Convey("Replace non finite values", func() {
+			body := []byte(`[{"metric": "NaN", "tags": {"host": "Infinity"}, "dps": {"1": NaN, "2": Infinity, "3": -Infinity, "4": 1.5}}]`)
+
+			So(string(replaceNonFiniteValues(body)), ShouldEqual,
+				`[{"metric": "NaN", "tags": {"host": "Infinity"}, "dps": {"1": null, "2": null, "3": null, "4": 1.5}}]`)
+		})
+
+		Convey("Keep escaped quotes inside strings", func() {
+			body := []byte(`[{"metric": "a\"NaN", "dps": {"1": NaN}}]`)
+
+			So(string(replaceNonFiniteValues(body)), ShouldEqual, `[{"metric": "a\"NaN", "dps": {"1": null}}]`)
+		})
+
+		Convey("Return valid bodies unchanged", func() {
+			body := []byte(`[{"metric": "cpu", "dps": {"1": 1}}]`)
+
+			So(string(replaceNonFiniteValues(body)), ShouldEqual, string(body))
+		})
+
+		Convey("Use standard field names by default", func() {
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+
+			So(responseFieldNames(dsInfo), ShouldBeEmpty)
+		})
+
+		Convey("Unmarshal responses with remapped field names", func() {
+			dsInfo := &models.DataSource{JsonData: simplejson.NewFromAny(map[string]interface{}{
+				"fieldNames": map[string]interface{}{
+					"metric":        "name",
+					"dps":           "datapoints",
+					"tags":          "labels",
+					"aggregateTags": "aggregatedLabels",
+				},
+			})}
+			body := []byte(`[{"name": "cpu", "labels": {"host": "a"}, "aggregatedLabels": ["dc"], "datapoints": {"1": 1.5}}]`)
+
+			responses, err := unmarshalResponses(body, responseFieldNames(dsInfo))
+			So(err, ShouldBeNil)
+			So(len(responses), ShouldEqual, 1)
+			So(responses[0].Metric, ShouldEqual, "cpu")
+			So(responses[0].Tags, ShouldResemble, map[string]string{"host": "a"})
+			So(responses[0].AggregateTags, ShouldResemble, []string{"dc"})
+			So(responses[0].DataPoints["1"].Float64, ShouldEqual, 1.5)
+		})
+
+		Convey("Unmarshal bare and wrapped responses", func() {
+			for _, body := range []string{
+				`[{"metric": "cpu", "dps": {"1": 1.5}}]`,
+				` {"results": [{"metric": "cpu", "dps": {"1": 1.5}}]}`,
+			} {
+				responses, err := unmarshalResponses([]byte(body), nil)
+				So(err, ShouldBeNil)
+				So(len(responses), ShouldEqual, 1)
+				So(responses[0].Metric, ShouldEqual, "cpu")
+				So(responses[0].DataPoints["1"].Float64, ShouldEqual, 1.5)
+			}
+
+			_, err := unmarshalResponses([]byte(`{"data": []}`), nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Unmarshal string encoded values", func() {
+			body := []byte(`[{"metric": "cpu", "dps": {"1": "123.4", "2": 5, "3": null, "4": "NaN"}}]`)
+
+			responses, err := unmarshalResponses(body, nil)
+			So(err, ShouldBeNil)
+			dps := responses[0].DataPoints
+			So(dps["1"].Valid, ShouldBeTrue)
+			So(dps["1"].Float64, ShouldEqual, 123.4)
+			So(dps["2"].Float64, ShouldEqual, 5)
+			So(dps["3"].Valid, ShouldBeFalse)
+			So(dps["4"].Valid, ShouldBeFalse)
+
+			_, err = unmarshalResponses([]byte(`[{"metric": "cpu", "dps": {"1": "high"}}]`), nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Transcode responses with a declared charset", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			query := &tsdb.Query{Model: simplejson.New()}
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "cpu", "aggregator": "sum"}}}
+			// "Zürich" in ISO-8859-1.
+			body := "[{\"metric\": \"cpu\", \"tags\": {\"city\": \"Z\xfcrich\"}, \"dps\": {\"60\": 1}}]"
+
+			res := newResponse(200, body)
+			res.Header.Set("Content-Type", "application/json; charset=ISO-8859-1")
+			queryRes, err := exec.parseResponse(dsInfo, query, data, res)
+			So(err, ShouldBeNil)
+			So(queryRes.Series[0].Tags["city"], ShouldEqual, "Zürich")
+
+			res = newResponse(200, `[{"metric": "cpu", "tags": {"city": "Zürich"}, "dps": {"60": 1}}]`)
+			res.Header.Set("Content-Type", "application/json")
+			queryRes, err = exec.parseResponse(dsInfo, query, data, res)
+			So(err, ShouldBeNil)
+			So(queryRes.Series[0].Tags["city"], ShouldEqual, "Zürich")
+
+			res = newResponse(200, body)
+			res.Header.Set("Content-Type", "application/json; charset=klingon")
+			_, err = exec.parseResponse(dsInfo, query, data, res)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Scrub sensitive debug headers", func() {
+			header := http.Header{}
+			header.Set("X-TSDB-Version", "2.4.0")
+			header.Add("Via", "1.1 proxy-a")
+			header.Add("Via", "1.1 proxy-b")
+			header.Set("Set-Cookie", "session=secret")
+			header.Set("WWW-Authenticate", "Basic")
+			header.Set("X-Api-Key", "secret")
+
+			So(debugHeaders(header), ShouldResemble, map[string]string{
+				"X-Tsdb-Version": "2.4.0",
+				"Via":            "1.1 proxy-a, 1.1 proxy-b",
+			})
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package opentsdb
+
+import (
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// hasRollup reports whether one of the rollup intervals evenly divides the
+// downsample interval, so that the downsampled data can be read from a rollup
+// table instead of raw data. OpenTSDB picks the table itself once a sub query
+// asks for rollups.
+func hasRollup(rollups []string, downsample time.Duration) bool {
+	for _, rollup := range rollups {
+		interval, err := parseInterval(rollup)
+		if err == nil && interval <= downsample && downsample%interval == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rollupIntervals returns the intervals of the rollup tables configured with
+// the datasource's rollupIntervals setting.
+func rollupIntervals(dsInfo *models.DataSource) []string {
+	return dsInfo.JsonData.Get("rollupIntervals").MustStringArray()
+}
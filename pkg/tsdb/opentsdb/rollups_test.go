@@ -0,0 +1,61 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbRollups(t *testing.T) {
+	Convey("OpenTsdb rollups testing", t, func() {
+
+		rollups := []string{"1h", "1d", "10m"}
+
+		Convey("Find rollups dividing the interval", func() {
+			So(hasRollup(rollups, 2*24*time.Hour), ShouldBeTrue)
+			So(hasRollup(rollups, 6*time.Hour), ShouldBeTrue)
+			So(hasRollup(rollups, 30*time.Minute), ShouldBeTrue)
+		})
+
+		Convey("Find no rollup for finer or uneven intervals", func() {
+			So(hasRollup(rollups, 5*time.Minute), ShouldBeFalse)
+			So(hasRollup(rollups, 15*time.Minute), ShouldBeFalse)
+			So(hasRollup(nil, time.Hour), ShouldBeFalse)
+		})
+
+		Convey("Build metric preferring rollups", func() {
+			server := newVersionServer("2.4.0")
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url: server.URL,
+				JsonData: simplejson.NewFromAny(map[string]interface{}{
+					"rollupIntervals": []interface{}{"1h", "1d"},
+				}),
+			}
+			query := &tsdb.Query{
+				Model: simplejson.New(),
+			}
+			query.Model.Set("metric", "cpu.average.percent")
+			query.Model.Set("aggregator", "sum")
+			query.Model.Set("downsampleInterval", "12h")
+			query.Model.Set("downsampleAggregator", "sum")
+			query.Model.Set("downsampleFillPolicy", "none")
+			query.Model.Set("preferRollups", true)
+
+			metric, err := (&OpenTsdbExecutor{}).buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["rollupUsage"], ShouldEqual, "ROLLUP_FALLBACK")
+
+			query.Model.Set("downsampleInterval", "5m")
+			metric, err = (&OpenTsdbExecutor{}).buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric, ShouldNotContainKey, "rollupUsage")
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package opentsdb
+
+// queryStats splits the statistics OpenTSDB adds to the responses of queries
+// sent with showStats or showSummary off the series. The summary comes as an
+// extra element without series, the stats of each series inline.
+func queryStats(responses []OpenTsdbResponse) ([]OpenTsdbResponse, map[string]interface{}) {
+	series := make([]OpenTsdbResponse, 0, len(responses))
+	seriesStats := make([]interface{}, 0)
+	var summary map[string]interface{}
+
+	for _, response := range responses {
+		if response.StatsSummary != nil {
+			summary = response.StatsSummary
+			continue
+		}
+		if response.Stats != nil {
+			seriesStats = append(seriesStats, map[string]interface{}{
+				"metric": response.Metric,
+				"tags":   response.Tags,
+				"stats":  response.Stats,
+			})
+		}
+		series = append(series, response)
+	}
+
+	if summary == nil && len(seriesStats) == 0 {
+		return series, nil
+	}
+
+	stats := map[string]interface{}{"series": seriesStats}
+	if summary != nil {
+		stats["summary"] = summary
+	}
+	return series, stats
+}
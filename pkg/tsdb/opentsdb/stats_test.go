@@ -0,0 +1,58 @@
+package opentsdb
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbStats(t *testing.T) {
+	Convey("OpenTsdb stats testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{
+			Model: simplejson.New(),
+		}
+		data := OpenTsdbQuery{
+			Start:   60000,
+			End:     120000,
+			Queries: []map[string]interface{}{{"metric": "sys.cpu.user"}},
+		}
+
+		Convey("Attach the stats and summary of the query", func() {
+			response := `[
+				{"metric": "sys.cpu.user", "tags": {"host": "web01"}, "dps": {"60": 1},
+				 "stats": {"hbaseTime": 12.5, "rowsScanned": 10000, "dpsPostFilter": 600}},
+				{"statsSummary": {"processingPreWriteTime": 20.1, "queryIdx_00": {"rowsScanned": 10000}, "successfulScan": 20}}
+			]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(len(queryRes.Series), ShouldEqual, 1)
+
+			stats := queryRes.Meta.Get("stats")
+			So(stats.Get("summary").Get("successfulScan").MustInt(), ShouldEqual, 20)
+			So(stats.Get("summary").GetPath("queryIdx_00", "rowsScanned").MustInt(), ShouldEqual, 10000)
+
+			series := stats.Get("series").GetIndex(0)
+			So(series.Get("metric").MustString(), ShouldEqual, "sys.cpu.user")
+			So(series.Get("stats").Get("rowsScanned").MustInt(), ShouldEqual, 10000)
+		})
+
+		Convey("Attach no stats by default", func() {
+			response := `[{"metric": "sys.cpu.user", "dps": {"60": 1}}]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+
+			_, ok := queryRes.Meta.CheckGet("stats")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
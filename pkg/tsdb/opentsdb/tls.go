@@ -0,0 +1,29 @@
+package opentsdb
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+)
+
+// queryHttpClient returns the client to send query with: httpClient, or one
+// skipping TLS certificate verification when query sets relaxedTLS. Relaxed
+// clients need the datasource's allowRelaxedTLS setting, so that the choice
+// stays with whoever administers the datasource.
+func queryHttpClient(dsInfo *models.DataSource, httpClient *http.Client, query *tsdb.Query) (*http.Client, bool, error) {
+	if !query.Model.Get("relaxedTLS").MustBool() {
+		return httpClient, false, nil
+	}
+	if !dsInfo.JsonData.Get("allowRelaxedTLS").MustBool() {
+		return nil, false, fmt.Errorf("Relaxed TLS isn't allowed for OpenTSDB datasource '%s'. Enable allowRelaxedTLS in its settings first", dsInfo.Name)
+	}
+
+	plog.Warn("Skipping TLS certificate verification of OpenTSDB query", "datasource", dsInfo.Name, "refId", query.RefId)
+	client, err := dsInfo.GetInsecureHttpClient()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to build HTTP client for OpenTSDB datasource '%s': %v", dsInfo.Name, err)
+	}
+	return client, true, nil
+}
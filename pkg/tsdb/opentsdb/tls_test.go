@@ -0,0 +1,72 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbTLS(t *testing.T) {
+	Convey("OpenTsdb TLS client testing", t, func() {
+
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"metric": "cpu", "dps": {"60": 1}}]`)
+		}))
+		defer server.Close()
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{
+			Id:       9180,
+			Name:     "internal",
+			Url:      server.URL,
+			JsonData: simplejson.New(),
+		}
+		query := &tsdb.Query{RefId: "A", Model: simplejson.New()}
+		query.Model.Set("metric", "cpu")
+		query.Model.Set("aggregator", "sum")
+		queryContext := &tsdb.TsdbQuery{
+			TimeRange: tsdb.NewTimeRange("1h", "now"),
+			Queries:   []*tsdb.Query{query},
+		}
+
+		Convey("Should use the strict client by default", func() {
+			strict := &http.Client{}
+			client, relaxed, err := queryHttpClient(dsInfo, strict, query)
+			So(err, ShouldBeNil)
+			So(relaxed, ShouldBeFalse)
+			So(client, ShouldEqual, strict)
+
+			_, err = exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should refuse relaxed clients unless the datasource allows them", func() {
+			query.Model.Set("relaxedTLS", true)
+
+			_, _, err := queryHttpClient(dsInfo, &http.Client{}, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should skip verification with the relaxed client", func() {
+			query.Model.Set("relaxedTLS", true)
+			dsInfo.JsonData.Set("allowRelaxedTLS", true)
+
+			client, relaxed, err := queryHttpClient(dsInfo, &http.Client{}, query)
+			So(err, ShouldBeNil)
+			So(relaxed, ShouldBeTrue)
+			So(client.Transport, ShouldNotBeNil)
+
+			resp, err := exec.Query(context.Background(), dsInfo, queryContext)
+			So(err, ShouldBeNil)
+			So(len(resp.Results["A"].Series), ShouldEqual, 1)
+			So(resp.Results["A"].Meta.Get("relaxedTLS").MustBool(), ShouldBeTrue)
+		})
+	})
+}
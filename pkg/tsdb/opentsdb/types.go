@@ -1,12 +1,70 @@
 package opentsdb
 
+import "github.com/grafana/grafana/pkg/components/null"
+
 type OpenTsdbQuery struct {
-	Start   int64                    `json:"start"`
-	End     int64                    `json:"end"`
-	Queries []map[string]interface{} `json:"queries"`
+	Start        int64                    `json:"start"`
+	End          int64                    `json:"end"`
+	Queries      []map[string]interface{} `json:"queries"`
+	MsResolution bool                     `json:"msResolution,omitempty"`
+	ShowQuery    bool                     `json:"showQuery,omitempty"`
+	ShowStats    bool                     `json:"showStats,omitempty"`
+	ShowSummary  bool                     `json:"showSummary,omitempty"`
+	UseMeta      bool                     `json:"useMeta,omitempty"`
+	ShowTSUIDs   bool                     `json:"showTSUIDs,omitempty"`
+	// GlobalAnnotations asks for the annotations not bound to any series.
+	GlobalAnnotations bool `json:"globalAnnotations,omitempty"`
+	// RelativeStart and RelativeEnd, like "1h-ago", replace Start and End in
+	// requests when set.
+	RelativeStart string `json:"-"`
+	RelativeEnd   string `json:"-"`
 }
 
 type OpenTsdbResponse struct {
-	Metric     string             `json:"metric"`
-	DataPoints map[string]float64 `json:"dps"`
+	Metric            string                   `json:"metric"`
+	DataPoints        map[string]OpenTsdbValue `json:"dps"`
+	Tags              map[string]string        `json:"tags"`
+	AggregateTags     []string                 `json:"aggregateTags"`
+	Annotations       []OpenTsdbAnnotation     `json:"annotations"`
+	GlobalAnnotations []OpenTsdbAnnotation     `json:"globalAnnotations"`
+	Query             *OpenTsdbSubQuery        `json:"query"`
+	Stats             map[string]interface{}   `json:"stats"`
+	StatsSummary      map[string]interface{}   `json:"statsSummary"`
+	Percentile        *float64                 `json:"percentile"`
+	TSUIDs            []string                 `json:"tsuids"`
+}
+
+// OpenTsdbSubQuery is the sub query OpenTSDB echoes back with each response
+// element when showQuery is set.
+type OpenTsdbSubQuery struct {
+	Index      *int   `json:"index"`
+	Aggregator string `json:"aggregator"`
+	Downsample string `json:"downsample"`
+}
+
+type OpenTsdbAnnotation struct {
+	Tsuid       string            `json:"tsuid"`
+	Description string            `json:"description"`
+	Notes       string            `json:"notes"`
+	Custom      map[string]string `json:"custom"`
+	StartTime   int64             `json:"startTime"`
+	EndTime     int64             `json:"endTime"`
+}
+
+type OpenTsdbLookupResponse struct {
+	Results      []OpenTsdbLookupResult `json:"results"`
+	StartIndex   int                    `json:"startIndex"`
+	TotalResults int                    `json:"totalResults"`
+}
+
+type OpenTsdbLookupResult struct {
+	Tsuid  string            `json:"tsuid"`
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// OpenTsdbValue is the value of a data point. Some OpenTSDB compatible
+// backends encode values as strings, which it accepts as well.
+type OpenTsdbValue struct {
+	null.Float
 }
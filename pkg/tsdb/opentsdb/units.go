@@ -0,0 +1,21 @@
+package opentsdb
+
+import "strings"
+
+// metricUnit returns the unit mapped to the longest metric prefix of
+// mappings matching metric, e.g. "bytes" for "net.bytes.*". A trailing "*"
+// of a prefix is optional.
+func metricUnit(mappings map[string]interface{}, metric string) string {
+	unit := ""
+	longest := -1
+	for prefix, value := range mappings {
+		prefix = strings.TrimSuffix(prefix, "*")
+		u, ok := value.(string)
+		if !ok || !strings.HasPrefix(metric, prefix) || len(prefix) <= longest {
+			continue
+		}
+		unit = u
+		longest = len(prefix)
+	}
+	return unit
+}
@@ -0,0 +1,51 @@
+package opentsdb
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbUnits(t *testing.T) {
+	Convey("OpenTsdb units testing", t, func() {
+
+		mappings := map[string]interface{}{
+			"net.bytes.*":     "bytes",
+			"net.":            "short",
+			"sys.cpu.percent": "percent",
+		}
+
+		Convey("Map metrics to the unit of their longest prefix", func() {
+			So(metricUnit(mappings, "net.bytes.in"), ShouldEqual, "bytes")
+			So(metricUnit(mappings, "net.packets.in"), ShouldEqual, "short")
+			So(metricUnit(mappings, "sys.cpu.percent"), ShouldEqual, "percent")
+			So(metricUnit(mappings, "sys.mem.used"), ShouldEqual, "")
+			So(metricUnit(nil, "net.bytes.in"), ShouldEqual, "")
+		})
+
+		Convey("Attach unit hints to query results", func() {
+			exec := &OpenTsdbExecutor{}
+			dsInfo := &models.DataSource{JsonData: simplejson.New()}
+			dsInfo.JsonData.Set("unitMappings", mappings)
+			query := &tsdb.Query{Model: simplejson.New()}
+			data := OpenTsdbQuery{Queries: []map[string]interface{}{{"metric": "net.bytes.*", "aggregator": "sum"}}}
+			response := `[
+				{"metric": "net.bytes.in", "tags": {"host": "web-1"}, "dps": {"60": 1}},
+				{"metric": "net.bytes.in", "tags": {"host": "web-2"}, "dps": {"60": 2}},
+				{"metric": "net.bytes.out", "dps": {"60": 3}},
+				{"metric": "sys.mem.used", "dps": {"60": 4}}
+			]`
+
+			queryRes, err := exec.parseResponse(dsInfo, query, data, newResponse(200, response))
+			So(err, ShouldBeNil)
+			So(queryRes.Meta.Get("seriesUnits").Interface(), ShouldResemble, map[string]string{
+				"net.bytes.in{host=web-1}": "bytes",
+				"net.bytes.in{host=web-2}": "bytes",
+				"net.bytes.out":            "bytes",
+			})
+		})
+	})
+}
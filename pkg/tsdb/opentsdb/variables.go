@@ -0,0 +1,110 @@
+package opentsdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// defaultMaxVariableExpansion is how many values a filter may list when the
+// datasource sets no maxVariableExpansion.
+const defaultMaxVariableExpansion = 100
+
+// literalFilterTypes are the filter types taking a "|" separated list of
+// values, which multi-value template variables expand into.
+var literalFilterTypes = map[string]bool{"literal_or": true, "iliteral_or": true, "not_literal_or": true}
+
+// checkVariableExpansion fails on sub queries whose tags or literal filters
+// list more values than the datasource's maxVariableExpansion allows, which
+// happens when a multi-value variable expands to all hosts. A limit of zero
+// disables the check.
+func checkVariableExpansion(dsInfo *models.DataSource, metric map[string]interface{}) error {
+	limit := dsInfo.JsonData.Get("maxVariableExpansion").MustInt(defaultMaxVariableExpansion)
+	if limit <= 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	if tags, ok := metric["tags"].(map[string]interface{}); ok {
+		for key, value := range tags {
+			if value, ok := value.(string); ok {
+				counts[key] = valueCount(value)
+			}
+		}
+	}
+	if filters, ok := metric["filters"].([]interface{}); ok {
+		for _, filter := range filters {
+			filter, ok := filter.(map[string]interface{})
+			if !ok || !literalFilterTypes[fmt.Sprint(filter["type"])] {
+				continue
+			}
+			key := fmt.Sprint(filter["tagk"])
+			if count := valueCount(fmt.Sprint(filter["filter"])); count > counts[key] {
+				counts[key] = count
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if counts[key] > limit {
+			return fmt.Errorf("Tag %s lists %d values, more than the maxVariableExpansion limit of %d. Narrow the template variable or use a wildcard filter instead", key, counts[key], limit)
+		}
+	}
+	return nil
+}
+
+// wildcardFilters turns filters whose value is empty or "*", as variables
+// set to all values tend to leave them, into wildcard filters matching every
+// value of their tag. They group by it unless they say otherwise, since
+// showing each host, say, is what selecting all of them usually means.
+func wildcardFilters(filters []interface{}) []interface{} {
+	normalized := make([]interface{}, 0, len(filters))
+	for _, filter := range filters {
+		values, ok := filter.(map[string]interface{})
+		if !ok || !matchesAll(values["filter"]) {
+			normalized = append(normalized, filter)
+			continue
+		}
+
+		wildcard := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			wildcard[key] = value
+		}
+		wildcard["type"] = "wildcard"
+		wildcard["filter"] = "*"
+		if _, ok := wildcard["groupBy"]; !ok {
+			wildcard["groupBy"] = true
+		}
+		normalized = append(normalized, wildcard)
+	}
+	return normalized
+}
+
+// matchesAll reports whether a filter value is missing, empty or "*".
+func matchesAll(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		value = strings.TrimSpace(value)
+		return value == "" || value == "*"
+	default:
+		return false
+	}
+}
+
+// valueCount returns the number of values of a "|" separated list such as
+// web01|web02, which may be wrapped in a filter like literal_or(web01|web02).
+func valueCount(value string) int {
+	if i := strings.Index(value, "("); i >= 0 && strings.HasSuffix(value, ")") {
+		value = value[i+1 : len(value)-1]
+	}
+	return strings.Count(value, "|") + 1
+}
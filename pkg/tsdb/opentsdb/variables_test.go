@@ -0,0 +1,105 @@
+package opentsdb
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbVariableExpansion(t *testing.T) {
+	Convey("OpenTsdb variable expansion testing", t, func() {
+
+		exec := &OpenTsdbExecutor{}
+		dsInfo := &models.DataSource{JsonData: simplejson.New()}
+		query := &tsdb.Query{Model: simplejson.New()}
+		query.Model.Set("metric", "cpu")
+		query.Model.Set("aggregator", "sum")
+
+		hosts := func(count int) string {
+			values := make([]string, count)
+			for i := range values {
+				values[i] = "web"
+			}
+			return strings.Join(values, "|")
+		}
+
+		Convey("Should count the values of lists", func() {
+			So(valueCount("web01"), ShouldEqual, 1)
+			So(valueCount("web01|web02"), ShouldEqual, 2)
+			So(valueCount("literal_or(web01|web02|web03)"), ShouldEqual, 3)
+		})
+
+		Convey("Should allow filters up to the limit", func() {
+			query.Model.Set("filters", []interface{}{
+				map[string]interface{}{"type": "literal_or", "tagk": "host", "filter": hosts(100), "groupBy": true},
+			})
+			query.Model.Set("tags", map[string]interface{}{"dc": hosts(100)})
+
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should reject filters over the limit", func() {
+			query.Model.Set("filters", []interface{}{
+				map[string]interface{}{"type": "literal_or", "tagk": "host", "filter": hosts(101), "groupBy": true},
+			})
+
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Tag host lists 101 values")
+		})
+
+		Convey("Should reject tags over the limit", func() {
+			query.Model.Set("tags", map[string]interface{}{"host": "literal_or(" + hosts(101) + ")"})
+
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should only count literal filters", func() {
+			query.Model.Set("filters", []interface{}{
+				map[string]interface{}{"type": "regexp", "tagk": "host", "filter": hosts(101), "groupBy": true},
+			})
+
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should turn empty and * filters into grouping wildcards", func() {
+			filters := []interface{}{
+				map[string]interface{}{"type": "literal_or", "tagk": "host", "filter": ""},
+				map[string]interface{}{"type": "regexp", "tagk": "dc", "filter": "*", "groupBy": false},
+				map[string]interface{}{"type": "literal_or", "tagk": "env", "filter": "prod", "groupBy": false},
+			}
+			query.Model.Set("filters", filters)
+
+			metric, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+			So(metric["filters"], ShouldResemble, []interface{}{
+				map[string]interface{}{"type": "wildcard", "tagk": "host", "filter": "*", "groupBy": true},
+				map[string]interface{}{"type": "wildcard", "tagk": "dc", "filter": "*", "groupBy": false},
+				map[string]interface{}{"type": "literal_or", "tagk": "env", "filter": "prod", "groupBy": false},
+			})
+			So(filters[0].(map[string]interface{})["filter"], ShouldEqual, "")
+		})
+
+		Convey("Should use the configured limit", func() {
+			query.Model.Set("tags", map[string]interface{}{"host": hosts(3)})
+
+			dsInfo.JsonData.Set("maxVariableExpansion", 2)
+			_, err := exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldNotBeNil)
+
+			dsInfo.JsonData.Set("maxVariableExpansion", 0)
+			query.Model.Set("tags", map[string]interface{}{"host": hosts(500)})
+			_, err = exec.buildMetric(context.Background(), dsInfo, http.DefaultClient, query)
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	version "github.com/hashicorp/go-version"
+)
+
+// versionCacheTTL is how long the version reported by a server is reused
+// before /api/version is asked again.
+const versionCacheTTL = 10 * time.Minute
+
+// configuredVersions are the OpenTSDB versions of the datasource's
+// tsdbVersion setting, used when the server doesn't report its version.
+var configuredVersions = map[int]string{
+	tsdbVersion21: "2.1",
+	2:             "2.2",
+	3:             "2.3",
+}
+
+type cachedVersion struct {
+	datasourceId int64
+	version      *version.Version
+	expires      time.Time
+}
+
+var versionCache = struct {
+	sync.Mutex
+	entries map[string]cachedVersion
+}{entries: make(map[string]cachedVersion)}
+
+// serverVersion returns the version of the OpenTSDB server of dsInfo. It asks
+// /api/version at most once per versionCacheTTL and falls back to the
+// configured tsdbVersion when the server can't be asked.
+func serverVersion(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header) *version.Version {
+	key := datasourceKey(dsInfo)
+	now := time.Now()
+
+	versionCache.Lock()
+	entry, ok := versionCache.entries[key]
+	versionCache.Unlock()
+
+	if !ok || now.After(entry.expires) {
+		v, err := fetchVersion(ctx, dsInfo, httpClient, headers)
+		if err != nil {
+			plog.Debug("Failed to get opentsdb version", "error", err)
+		}
+		// Canceled queries say nothing about the server, so they don't get
+		// the configured version cached for it.
+		if ctx.Err() != nil {
+			return configuredVersion(dsInfo)
+		}
+		entry = cachedVersion{datasourceId: dsInfo.Id, version: v, expires: now.Add(versionCacheTTL)}
+
+		// Entries of earlier settings of the datasource are never asked for
+		// again, and expired ones would be fetched anew.
+		versionCache.Lock()
+		for k, cached := range versionCache.entries {
+			if k != key && (cached.datasourceId == dsInfo.Id || now.After(cached.expires)) {
+				delete(versionCache.entries, k)
+			}
+		}
+		versionCache.entries[key] = entry
+		versionCache.Unlock()
+	}
+
+	if entry.version != nil {
+		return entry.version
+	}
+	return configuredVersion(dsInfo)
+}
+
+// datasourceKey identifies a version of a datasource's settings in caches,
+// so that saving a datasource invalidates what was cached for it.
+func datasourceKey(dsInfo *models.DataSource) string {
+	return fmt.Sprintf("%d:%s:%d", dsInfo.Id, dsInfo.Url, dsInfo.Updated.UnixNano())
+}
+
+// configuredVersion returns the version of the datasource's tsdbVersion setting.
+func configuredVersion(dsInfo *models.DataSource) *version.Version {
+	v, ok := configuredVersions[tsdbVersion(dsInfo)]
+	if !ok {
+		v = configuredVersions[tsdbVersion21]
+	}
+	return version.Must(version.NewVersion(v))
+}
+
+// requireVersion returns an error naming feature when the OpenTSDB server of
+// dsInfo is older than minimum. The server is asked with the context, client
+// and headers of query.
+func requireVersion(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, query *tsdb.Query, minimum string, feature string) error {
+	headers, err := queryHeaders(query)
+	if err != nil {
+		return err
+	}
+	if serverVersion(ctx, dsInfo, httpClient, headers).LessThan(version.Must(version.NewVersion(minimum))) {
+		return fmt.Errorf("%s requires OpenTSDB >= %s", feature, minimum)
+	}
+	return nil
+}
+
+// releaseVersion returns the release of v, without any pre-release part.
+// Release candidates such as 2.4.0RC2 already have the features of their
+// release, but go-version ranks them below it.
+func releaseVersion(v *version.Version) *version.Version {
+	if v.Prerelease() == "" {
+		return v
+	}
+	segments := make([]string, 0, len(v.Segments()))
+	for _, segment := range v.Segments() {
+		segments = append(segments, strconv.Itoa(segment))
+	}
+	return version.Must(version.NewVersion(strings.Join(segments, ".")))
+}
+
+func fetchVersion(ctx context.Context, dsInfo *models.DataSource, httpClient *http.Client, headers http.Header) (*version.Version, error) {
+	u, err := apiURL(dsInfo, "version")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newRequest(dsInfo, http.MethodGet, u.String(), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := doRequest(ctx, dsInfo, httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := readResponse(dsInfo, res)
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+
+	v, err := version.NewVersion(info.Version)
+	if err != nil {
+		return nil, err
+	}
+	return releaseVersion(v), nil
+}
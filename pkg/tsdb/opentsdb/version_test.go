@@ -0,0 +1,141 @@
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/tsdb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenTsdbVersion(t *testing.T) {
+	Convey("OpenTsdb version testing", t, func() {
+
+		ctx := context.Background()
+		query := &tsdb.Query{Model: simplejson.New()}
+
+		Convey("Use the version reported by the server", func() {
+			server := newVersionServer("2.4.0RC2")
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.NewFromAny(map[string]interface{}{"tsdbVersion": 1}),
+			}
+
+			So(serverVersion(ctx, dsInfo, server.Client(), nil).String(), ShouldEqual, "2.4.0")
+			So(requireVersion(ctx, dsInfo, server.Client(), query, "2.3", "runAll downsampling"), ShouldBeNil)
+			So(requireVersion(ctx, dsInfo, server.Client(), query, "2.4", "preferRollups"), ShouldBeNil)
+		})
+
+		Convey("Ask the server with the headers of the query", func() {
+			var tenant string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant = r.Header.Get("X-Tenant")
+				fmt.Fprint(w, `{"version": "2.4.0"}`)
+			}))
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+			query.Model.Set("httpHeaders", map[string]interface{}{"X-Tenant": "team-a"})
+
+			So(requireVersion(ctx, dsInfo, server.Client(), query, "2.4", "preferRollups"), ShouldBeNil)
+			So(tenant, ShouldEqual, "team-a")
+		})
+
+		Convey("Don't cache the version of canceled queries", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"version": "2.4.0"}`)
+			}))
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.NewFromAny(map[string]interface{}{"tsdbVersion": 2}),
+			}
+
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			So(serverVersion(canceled, dsInfo, server.Client(), nil).String(), ShouldEqual, "2.2.0")
+			So(serverVersion(ctx, dsInfo, server.Client(), nil).String(), ShouldEqual, "2.4.0")
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Ask the server once per datasource", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"version": "2.3.1"}`)
+			}))
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+			}
+
+			serverVersion(ctx, dsInfo, server.Client(), nil)
+			serverVersion(ctx, dsInfo, server.Client(), nil)
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Keep one entry per datasource across saves", func() {
+			server := newVersionServer("2.4.0")
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Id:       9128,
+				Url:      server.URL,
+				JsonData: simplejson.New(),
+				Updated:  time.Now(),
+			}
+
+			serverVersion(ctx, dsInfo, server.Client(), nil)
+			dsInfo.Updated = dsInfo.Updated.Add(time.Second)
+			serverVersion(ctx, dsInfo, server.Client(), nil)
+
+			entries := 0
+			versionCache.Lock()
+			for _, entry := range versionCache.entries {
+				if entry.datasourceId == dsInfo.Id {
+					entries++
+				}
+			}
+			versionCache.Unlock()
+			So(entries, ShouldEqual, 1)
+		})
+
+		Convey("Fall back to the configured version", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer server.Close()
+			dsInfo := &models.DataSource{
+				Url:      server.URL,
+				JsonData: simplejson.NewFromAny(map[string]interface{}{"tsdbVersion": 2}),
+			}
+
+			So(serverVersion(ctx, dsInfo, server.Client(), nil).String(), ShouldEqual, "2.2.0")
+
+			err := requireVersion(ctx, dsInfo, server.Client(), query, "2.3", "runAll downsampling")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "runAll downsampling requires OpenTSDB >= 2.3")
+		})
+	})
+}
+
+func newVersionServer(v string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"version": %q}`, v)
+	}))
+}